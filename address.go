@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// defaultPorts are the ports dropped from normalized addresses
+var defaultPorts = map[string]string{"http": "80", "https": "443"}
+
+// normalizeAddress parses a management address given as a full URL or as
+// host[:port][/path] and returns its normalized form. HTTPS is implied, so
+// an HTTPS address is written without its scheme; default ports and
+// trailing slashes are removed and bare IPv6 literals are bracketed.
+// The normalized form is used as target label and to build API URLs.
+func normalizeAddress(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", fmt.Errorf("address is empty")
+	}
+	if !strings.Contains(raw, "://") {
+		if ip := net.ParseIP(raw); ip != nil && strings.Contains(raw, ":") {
+			raw = "[" + raw + "]"
+		}
+		raw = "https://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid address: %w", err)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if _, ok := defaultPorts[u.Scheme]; !ok {
+		return "", fmt.Errorf("unsupported scheme %q, expected http or https", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("address %q has no host", raw)
+	}
+	if u.User != nil {
+		return "", fmt.Errorf("address %q must not contain credentials", u.Redacted())
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("address %q must not contain a query or fragment", raw)
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port := u.Port(); port != "" {
+		n, err := strconv.Atoi(port)
+		if err != nil || n < 1 || n > 65535 {
+			return "", fmt.Errorf("address %q has invalid port %q", raw, port)
+		}
+		if port = strconv.Itoa(n); port != defaultPorts[u.Scheme] {
+			host += ":" + port
+		}
+	}
+	u.Host = host
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+
+	if u.Scheme == "https" {
+		return strings.TrimPrefix(u.String(), "https://"), nil
+	}
+	return u.String(), nil
+}
+
+// apiURL builds the URL of an API call below a normalized address
+func apiURL(address string, elem ...string) string {
+	if !strings.Contains(address, "://") {
+		address = "https://" + address
+	}
+	return address + "/api/" + strings.Join(elem, "/")
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// invalidSessionMessage is the response of the status object the array
+// returns for a command sent with an expired or unknown session key. Its
+// return code is not specific to this case (a failed login reports 2 as
+// well), so the message is what identifies it.
+const invalidSessionMessage = "Invalid sessionkey"
+
+// APIError is a command failure the array reports with HTTP 200 and a
+// status object whose response-type is Error
+type APIError struct {
+	ReturnCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API error %d: %s", e.ReturnCode, e.Message)
+}
+
+// Is makes errors.Is(err, errErrorResponse) match any API error
+func (e *APIError) Is(target error) bool {
+	return target == errErrorResponse
+}
+
+// InvalidSession reports whether the array rejected the session key, in
+// which case logging in again may fix the request
+func (e *APIError) InvalidSession() bool {
+	return strings.EqualFold(strings.TrimSpace(e.Message), invalidSessionMessage)
+}
+
+// statusAPIError returns the error reported by the status objects of a
+// response, or nil if the command succeeded
+func statusAPIError(objects []Object) *APIError {
+	for _, obj := range objects {
+		if obj.Name != "status" && obj.Basetype != "status" {
+			continue
+		}
+		responseType, _ := findProperty(obj, "response-type")
+		numeric, _ := findProperty(obj, "response-type-numeric")
+		if !strings.EqualFold(responseType, "Error") && numeric != "1" {
+			continue
+		}
+		apiErr := &APIError{}
+		apiErr.Message, _ = findProperty(obj, "response")
+		if code, ok := findProperty(obj, "return-code"); ok {
+			apiErr.ReturnCode, _ = strconv.Atoi(strings.TrimSpace(code))
+		}
+		return apiErr
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestStatusAPIError(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected *APIError
+	}{
+		{
+			name: "success",
+			body: `<RESPONSE><OBJECT basetype="status" name="status" oid="1">
+	<PROPERTY name="response-type">Success</PROPERTY>
+	<PROPERTY name="response-type-numeric">0</PROPERTY>
+	<PROPERTY name="response">Command completed successfully.</PROPERTY>
+	<PROPERTY name="return-code">0</PROPERTY>
+</OBJECT></RESPONSE>`,
+		},
+		{
+			name: "error",
+			body: `<RESPONSE><OBJECT basetype="status" name="status" oid="1">
+	<PROPERTY name="response-type">Error</PROPERTY>
+	<PROPERTY name="response-type-numeric">1</PROPERTY>
+	<PROPERTY name="response">The command is ambiguous. Please check the help for this command.</PROPERTY>
+	<PROPERTY name="return-code">-10028</PROPERTY>
+</OBJECT></RESPONSE>`,
+			expected: &APIError{ReturnCode: -10028, Message: "The command is ambiguous. Please check the help for this command."},
+		},
+		{
+			name:     "status object found by basetype",
+			body:     `<RESPONSE><OBJECT basetype="status" name="result"><PROPERTY name="response-type-numeric">1</PROPERTY><PROPERTY name="return-code">5</PROPERTY></OBJECT></RESPONSE>`,
+			expected: &APIError{ReturnCode: 5},
+		},
+		{
+			name: "data without status",
+			body: `<RESPONSE><OBJECT basetype="drives" name="drive"><PROPERTY name="response-type">Error</PROPERTY></OBJECT></RESPONSE>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var response Response
+			if err := xml.Unmarshal([]byte(tt.body), &response); err != nil {
+				t.Fatal(err)
+			}
+			apiErr := statusAPIError(response.Objects)
+			switch {
+			case tt.expected == nil && apiErr != nil:
+				t.Errorf("Expected no error, got %v", apiErr)
+			case tt.expected != nil && (apiErr == nil || *apiErr != *tt.expected):
+				t.Errorf("Expected %+v, got %+v", tt.expected, apiErr)
+			}
+		})
+	}
+}
+
+func TestAPIErrorInvalidSession(t *testing.T) {
+	for _, apiErr := range []*APIError{
+		{ReturnCode: 2, Message: "Invalid sessionkey"},
+		{Message: "invalid sessionkey "},
+	} {
+		if !apiErr.InvalidSession() {
+			t.Errorf("Expected %v to reject the session", apiErr)
+		}
+	}
+	for _, apiErr := range []*APIError{
+		{ReturnCode: -10028, Message: "The command is ambiguous."},
+		{ReturnCode: 2, Message: "Authentication Unsuccessful"},
+		{ReturnCode: 2},
+		{ReturnCode: -1, Message: "Unable to show session details."},
+	} {
+		if apiErr.InvalidSession() {
+			t.Errorf("Expected %v not to reject the session", apiErr)
+		}
+	}
+	if !errors.Is(&APIError{ReturnCode: 1}, errErrorResponse) {
+		t.Error("Expected API errors to match errErrorResponse")
+	}
+}
+
+func TestGetAPIError(t *testing.T) {
+	var logins atomic.Int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/api/login/"):
+			logins.Add(1)
+			_, _ = w.Write([]byte(`<RESPONSE><OBJECT basetype="status" name="status"><PROPERTY name="response-type">Success</PROPERTY><PROPERTY name="response">key</PROPERTY><PROPERTY name="return-code">1</PROPERTY></OBJECT></RESPONSE>`))
+		case r.URL.Path == "/api/show/version":
+			_, _ = w.Write([]byte(`<RESPONSE><OBJECT basetype="versions" name="controller-a-versions"><PROPERTY name="bundle-version">1.0</PROPERTY></OBJECT></RESPONSE>`))
+		case r.URL.Path == "/api/show/disks":
+			_, _ = w.Write([]byte(`<RESPONSE><OBJECT basetype="status" name="status"><PROPERTY name="response-type">Error</PROPERTY><PROPERTY name="response">Unable to show disks.</PROPERTY><PROPERTY name="return-code">-3</PROPERTY></OBJECT></RESPONSE>`))
+		default:
+			_, _ = w.Write([]byte(`<RESPONSE></RESPONSE>`))
+		}
+	}))
+	defer server.Close()
+
+	client := newMSAClient(server.URL[8:], "user", "pass", 10*time.Second, &tls.Config{InsecureSkipVerify: true})
+	client.target = "api-error-test"
+	defer deleteTargetMetrics("api-error-test")
+
+	_, err := client.GetDocument(t.Context(), "disks")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.ReturnCode != -3 || apiErr.Message != "Unable to show disks." {
+		t.Fatalf("Expected API error -3, got %v", err)
+	}
+	if logins.Load() != 1 {
+		t.Errorf("Expected a command error not to log in again, got %d logins", logins.Load())
+	}
+
+	if err := scrapeMSA(t.Context(), client, NewMetricStoreWithRegisterer(prometheus.NewRegistry()), getMetrics()); err != nil {
+		t.Fatalf("scrapeMSA failed: %v", err)
+	}
+	if v := testutil.ToFloat64(apiRequestErrors.WithLabelValues("api-error-test", "disks", "error_response")); v != 1 {
+		t.Errorf("Expected 1 disks error response, got %v", v)
+	}
+}
+
+func TestLoginAPIError(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<RESPONSE><OBJECT basetype="status" name="status"><PROPERTY name="response-type">Error</PROPERTY><PROPERTY name="response">Authentication Unsuccessful</PROPERTY><PROPERTY name="return-code">2</PROPERTY></OBJECT></RESPONSE>`))
+	}))
+	defer server.Close()
+
+	_, err := newLoggedInClient(t.Context(), server.URL, "user", "wrong", 10*time.Second, &tls.Config{InsecureSkipVerify: true})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Message != "Authentication Unsuccessful" {
+		t.Errorf("Expected failed login to return the API error, got %v", err)
+	}
+}
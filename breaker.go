@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states, exported as the value of msa_login_circuit_state
+const (
+	circuitClosed = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// maxLoginBackoff caps how long an open circuit breaker rejects logins
+const maxLoginBackoff = 10 * time.Minute
+
+// errCircuitOpen is returned for logins rejected by an open circuit breaker
+var errCircuitOpen = errors.New("login circuit breaker open")
+
+// circuitBreaker stops logging in to an array after repeated login
+// failures. Once open it rejects logins until its backoff has passed,
+// then lets a single login through (half-open). A successful login
+// closes it, another failure opens it again with twice the backoff.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	target    string
+	adhoc     bool
+	threshold int
+	backoff   time.Duration
+
+	state     int
+	failures  int
+	cooldown  time.Duration
+	openUntil time.Time
+}
+
+// newCircuitBreaker creates a closed breaker, or nil if threshold is 0
+func newCircuitBreaker(target string, threshold int, backoff time.Duration) *circuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	b := &circuitBreaker{target: target, threshold: threshold, backoff: backoff}
+	b.setState(circuitClosed)
+	return b
+}
+
+// allow returns errCircuitOpen if a login must not be attempted now
+func (b *circuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == circuitOpen {
+		if wait := time.Until(b.openUntil); wait > 0 {
+			return fmt.Errorf("%w after %d failed logins, retrying in %s", errCircuitOpen, b.failures, wait.Round(time.Second))
+		}
+		b.setState(circuitHalfOpen)
+	}
+	return nil
+}
+
+// record updates the breaker with the result of a login
+func (b *circuitBreaker) record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		b.failures = 0
+		b.cooldown = 0
+		b.setState(circuitClosed)
+		return
+	}
+
+	b.failures++
+	if b.state != circuitHalfOpen && b.failures < b.threshold {
+		return
+	}
+	if b.cooldown == 0 {
+		b.cooldown = b.backoff
+	} else {
+		b.cooldown = min(2*b.cooldown, maxLoginBackoff)
+	}
+	b.openUntil = time.Now().Add(b.cooldown)
+	b.setState(circuitOpen)
+}
+
+// setState changes the state and updates the state metric
+func (b *circuitBreaker) setState(state int) {
+	b.state = state
+	if b.adhoc {
+		return
+	}
+	loginCircuitState.WithLabelValues(b.target).Set(float64(state))
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	b := newCircuitBreaker("breaker-test", 2, 50*time.Millisecond)
+	defer deleteTargetMetrics("breaker-test")
+	state := func() float64 {
+		return testutil.ToFloat64(loginCircuitState.WithLabelValues("breaker-test"))
+	}
+	failed := errors.New("authentication failed")
+
+	b.record(failed)
+	if err := b.allow(); err != nil || state() != circuitClosed {
+		t.Fatalf("Expected breaker to stay closed below the threshold, got %v", err)
+	}
+
+	b.record(failed)
+	if err := b.allow(); !errors.Is(err, errCircuitOpen) || state() != circuitOpen {
+		t.Fatalf("Expected breaker to open at the threshold, got %v", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if err := b.allow(); err != nil || state() != circuitHalfOpen {
+		t.Fatalf("Expected breaker to be half-open after the backoff, got %v", err)
+	}
+
+	// A failed trial login opens the breaker with a doubled backoff
+	b.record(failed)
+	if b.cooldown != 100*time.Millisecond || state() != circuitOpen {
+		t.Errorf("Expected backoff of 100ms, got %v", b.cooldown)
+	}
+
+	b.openUntil = time.Now()
+	if err := b.allow(); err != nil {
+		t.Fatalf("Expected trial login to be allowed, got %v", err)
+	}
+	b.record(nil)
+	if err := b.allow(); err != nil || state() != circuitClosed {
+		t.Errorf("Expected breaker to close after a successful login, got %v", err)
+	}
+
+	if newCircuitBreaker("breaker-test", 0, time.Second) != nil {
+		t.Error("Expected threshold 0 to disable the breaker")
+	}
+}
+
+func TestMSAClientLoginBreaker(t *testing.T) {
+	var logins atomic.Int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logins.Add(1)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	client := newMSAClient(server.URL[8:], "user", "wrong", 10*time.Second, &tls.Config{InsecureSkipVerify: true})
+	client.breaker = newCircuitBreaker("breaker-client-test", 1, time.Hour)
+	defer deleteTargetMetrics("breaker-client-test")
+
+	if _, err := client.GetDocument(t.Context(), "system"); err == nil || errors.Is(err, errCircuitOpen) {
+		t.Fatalf("Expected first login to fail against the array, got %v", err)
+	}
+	_, err := client.GetDocument(t.Context(), "system")
+	if !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("Expected open circuit breaker, got %v", err)
+	}
+	if reason := errorReason(err); reason != "circuit_open" {
+		t.Errorf("Expected reason circuit_open, got %s", reason)
+	}
+	if n := logins.Load(); n != 1 {
+		t.Errorf("Expected 1 login attempt, got %d", n)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector modes
+const (
+	modeBackground = "background"
+	modeOnDemand   = "ondemand"
+)
+
+// msaCollector implements prometheus.Collector and reads the array when
+// Prometheus scrapes. Results are cached for a short TTL so parallel
+// scrapes from HA Prometheus pairs do not both hit the array.
+type msaCollector struct {
+	manager *targetManager
+	name    string
+	ttl     time.Duration
+
+	mu       sync.Mutex
+	cached   []prometheus.Metric
+	cachedAt time.Time
+}
+
+// newMSACollector creates an on-demand collector for the named target
+func newMSACollector(manager *targetManager, name string, ttl time.Duration) *msaCollector {
+	return &msaCollector{
+		manager: manager,
+		name:    name,
+		ttl:     ttl,
+	}
+}
+
+// Describe implements prometheus.Collector. The collector is unchecked
+// since the label values depend on what the array returns.
+func (c *msaCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector
+func (c *msaCollector) Collect(ch chan<- prometheus.Metric) {
+	// Holding the lock makes concurrent scrapes wait for a single read of the array
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cachedAt.IsZero() || time.Since(c.cachedAt) >= c.ttl {
+		c.cached = c.collect()
+		c.cachedAt = time.Now()
+	}
+	for _, metric := range c.cached {
+		ch <- metric
+	}
+}
+
+// collect scrapes the target into a fresh metric store and returns its metrics
+func (c *msaCollector) collect() []prometheus.Metric {
+	cfg, metrics := c.manager.state.Get()
+	target, ok := cfg.FindTarget(c.name)
+	if !ok {
+		return nil
+	}
+
+	// The result is shared by all scrapes waiting on the cache, so it is
+	// bounded by the target timeout rather than by the context of one request
+	ctx, cancel := context.WithTimeout(c.manager.ctx, time.Duration(target.Timeout))
+	defer cancel()
+
+	metricStore := NewMetricStoreWithRegisterer(prometheus.NewRegistry())
+	start := time.Now()
+	auth, err := cfg.Credentials(target)
+	if err == nil {
+		err = scrapeTarget(ctx, c.manager.pool, c.name, target, auth, metricStore, metrics)
+	}
+	c.manager.observe(c.name, start, err)
+	if err != nil {
+		slog.Warn("Failed to scrape target", "target", c.name, "err", err)
+		return nil
+	}
+
+	return metricStore.Metrics()
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newCountingTestServer wraps the probe test server and counts version requests
+func newCountingTestServer(t *testing.T, versions *atomic.Int32) *httptest.Server {
+	t.Helper()
+	backend := newProbeTestServer(t)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/show/version" {
+			versions.Add(1)
+		}
+		backend.Config.Handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestOnDemandCollector(t *testing.T) {
+	var versions atomic.Int32
+	server := newCountingTestServer(t, &versions)
+
+	configFile := writeTestFile(t, "config.yml", `
+targets:
+  - name: array1
+    host: `+server.URL[8:]+`
+    login: probeuser
+    password: probepass
+    tls_config:
+      insecure_skip_verify: true
+`)
+	state, err := NewSafeConfig(&configLoader{configFile: configFile, timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("NewSafeConfig failed: %v", err)
+	}
+
+	manager := newTargetManager(t.Context(), state, newClientPool(), time.Hour)
+	manager.mode = modeOnDemand
+	manager.cacheTTL = 200 * time.Millisecond
+	manager.Sync()
+
+	if versions.Load() != 0 {
+		t.Fatal("On-demand mode must not scrape before the first collection")
+	}
+
+	t.Run("collect reads the array", func(t *testing.T) {
+		expected := `
+# HELP msa_disk_temperature Temperature
+# TYPE msa_disk_temperature gauge
+msa_disk_temperature{location="1.1",serial="PROBE123",target="array1"} 38
+`
+		if err := testutil.GatherAndCompare(manager, strings.NewReader(expected), "msa_disk_temperature"); err != nil {
+			t.Error(err)
+		}
+		if versions.Load() != 1 {
+			t.Errorf("Expected 1 read of the array, got %d", versions.Load())
+		}
+	})
+
+	t.Run("parallel scrapes share the cache", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := manager.Gather(); err != nil {
+					t.Errorf("Gather failed: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+		if versions.Load() != 1 {
+			t.Errorf("Expected cached result within TTL, got %d reads", versions.Load())
+		}
+	})
+
+	t.Run("expired cache reads again", func(t *testing.T) {
+		time.Sleep(250 * time.Millisecond)
+		if _, err := manager.Gather(); err != nil {
+			t.Fatalf("Gather failed: %v", err)
+		}
+		if versions.Load() != 2 {
+			t.Errorf("Expected 2 reads after TTL expiry, got %d", versions.Load())
+		}
+	})
+}
+
+func TestOnDemandCollectorFailure(t *testing.T) {
+	server := newProbeTestServer(t)
+	configFile := writeTestFile(t, "config.yml", `
+targets:
+  - name: array1
+    host: `+server.URL[8:]+`
+    login: probeuser
+    password: wrongpass
+    tls_config:
+      insecure_skip_verify: true
+`)
+	state, err := NewSafeConfig(&configLoader{configFile: configFile, timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("NewSafeConfig failed: %v", err)
+	}
+
+	manager := newTargetManager(t.Context(), state, newClientPool(), time.Hour)
+	manager.mode = modeOnDemand
+	manager.Sync()
+
+	families, err := manager.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %v", err)
+	}
+	if len(families) != 0 {
+		t.Errorf("Expected no metrics for a failed collection, got %d families", len(families))
+	}
+}
+
+func TestOnDemandCollectorConcurrentTargets(t *testing.T) {
+	// Both arrays hold their version request until the other one has been asked
+	var versions atomic.Int32
+	both := make(chan struct{})
+	backend := newProbeTestServer(t)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/show/version" {
+			if versions.Add(1) == 2 {
+				close(both)
+			}
+			select {
+			case <-both:
+			case <-time.After(5 * time.Second):
+				t.Error("Targets were not collected concurrently")
+			}
+		}
+		backend.Config.Handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	configFile := writeTestFile(t, "config.yml", `
+targets:
+  - name: array1
+    host: `+server.URL[8:]+`
+    login: probeuser
+    password: probepass
+    tls_config:
+      insecure_skip_verify: true
+  - name: array2
+    host: `+server.URL[8:]+`/
+    login: probeuser
+    password: probepass
+    tls_config:
+      insecure_skip_verify: true
+`)
+	state, err := NewSafeConfig(&configLoader{configFile: configFile, timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("NewSafeConfig failed: %v", err)
+	}
+
+	manager := newTargetManager(t.Context(), state, newClientPool(), time.Hour)
+	manager.mode = modeOnDemand
+	manager.Sync()
+
+	if _, err := manager.Gather(); err != nil {
+		t.Fatalf("Gather failed: %v", err)
+	}
+	if versions.Load() != 2 {
+		t.Errorf("Expected both arrays to be read, got %d reads", versions.Load())
+	}
+}
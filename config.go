@@ -0,0 +1,409 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"go.yaml.in/yaml/v2"
+)
+
+// labelNameRE matches valid Prometheus label names
+var labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// reservedLabels are set by the exporter itself and cannot be used as extra labels
+var reservedLabels = map[string]bool{
+	"target": true,
+}
+
+// Config is the exporter configuration file
+type Config struct {
+	AuthModules map[string]AuthModule `yaml:"auth_modules"`
+	Targets     []TargetConfig        `yaml:"targets"`
+}
+
+// AuthModule holds the credentials used to log in to an MSA array
+type AuthModule struct {
+	Login    string `yaml:"login"`
+	Password string `yaml:"password"`
+	// PasswordFile is read instead of Password and again after a failed login
+	PasswordFile string `yaml:"password_file"`
+}
+
+// TLSConfig configures TLS for connections to an MSA array
+type TLSConfig struct {
+	// InsecureSkipVerify disables certificate verification and must be set explicitly
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+	CAFile             string `yaml:"ca_file"`
+	ServerName         string `yaml:"server_name"`
+	// CertFile and KeyFile are an optional client certificate
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// MinVersion is the minimum TLS version: TLS10, TLS11, TLS12 or TLS13
+	MinVersion string `yaml:"min_version"`
+	// PinnedSHA256 are SHA-256 fingerprints of accepted array certificates.
+	// A pinned certificate is trusted instead of verifying its chain.
+	PinnedSHA256 []string `yaml:"pinned_sha256"`
+}
+
+// RetryConfig configures retries of API requests and the login circuit breaker
+type RetryConfig struct {
+	// MaxRetries is the number of retries of a failed show request, 0 disables retries
+	MaxRetries int `yaml:"max_retries"`
+	// Backoff is the base delay before the first retry, doubled for every further one
+	Backoff model.Duration `yaml:"backoff"`
+	// LoginFailureThreshold is the number of consecutive failed logins that open
+	// the circuit breaker, 0 disables it
+	LoginFailureThreshold int `yaml:"login_failure_threshold"`
+	// LoginBackoff is how long the open breaker rejects logins, doubled while they keep failing
+	LoginBackoff model.Duration `yaml:"login_backoff"`
+}
+
+// TargetConfig describes a single MSA array
+type TargetConfig struct {
+	Name string `yaml:"name"`
+	// Host is the management address as URL or host[:port], optionally with a base path
+	Host string `yaml:"host"`
+	// Hosts are the management addresses of both controllers in order of preference
+	Hosts []string `yaml:"hosts"`
+	// FailbackAfter is how long to stay on a partner controller before trying the first one again
+	FailbackAfter model.Duration    `yaml:"failback_after"`
+	AuthModule    string            `yaml:"auth_module"`
+	Login         string            `yaml:"login"`
+	Password      string            `yaml:"password"`
+	PasswordFile  string            `yaml:"password_file"`
+	Timeout       model.Duration    `yaml:"timeout"`
+	TLSConfig     TLSConfig         `yaml:"tls_config"`
+	Labels        map[string]string `yaml:"labels"`
+	// Concurrency is the number of API paths fetched in parallel during a scrape
+	Concurrency int `yaml:"concurrency"`
+	// MaxSessions caps the sessions the exporter holds open on the array, 0 means unlimited
+	MaxSessions int         `yaml:"max_sessions"`
+	Retry       RetryConfig `yaml:"retry"`
+}
+
+// defaultFailbackAfter is how long a target stays on its partner controller by default
+const defaultFailbackAfter = 10 * time.Minute
+
+// DefaultTLSConfig verifies array certificates against the system roots
+var DefaultTLSConfig = TLSConfig{}
+
+// tlsVersions maps min_version values to TLS versions
+var tlsVersions = map[string]uint16{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+// DefaultRetryConfig retries transient failures twice and backs off logins
+// after three failures in a row
+var DefaultRetryConfig = RetryConfig{
+	MaxRetries:            2,
+	Backoff:               model.Duration(500 * time.Millisecond),
+	LoginFailureThreshold: 3,
+	LoginBackoff:          model.Duration(30 * time.Second),
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler and applies defaults
+func (t *TargetConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*t = TargetConfig{TLSConfig: DefaultTLSConfig, Retry: DefaultRetryConfig}
+	type plain TargetConfig
+	return unmarshal((*plain)(t))
+}
+
+// LoadConfig reads and parses a configuration file
+func LoadConfig(filename string) (*Config, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(content, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", filename, err)
+	}
+	return cfg, nil
+}
+
+// ApplyDefaults fills unset per-target options from the command line values
+func (c *Config) ApplyDefaults(timeout time.Duration) {
+	for i := range c.Targets {
+		c.Targets[i].normalizeAddresses()
+		if c.Targets[i].Name == "" {
+			if addresses := c.Targets[i].Addresses(); len(addresses) > 0 {
+				c.Targets[i].Name = addresses[0]
+			}
+		}
+		if c.Targets[i].FailbackAfter == 0 {
+			c.Targets[i].FailbackAfter = model.Duration(defaultFailbackAfter)
+		}
+		if c.Targets[i].Timeout == 0 {
+			c.Targets[i].Timeout = model.Duration(timeout)
+		}
+		if c.Targets[i].Concurrency == 0 {
+			c.Targets[i].Concurrency = defaultConcurrency
+		}
+	}
+}
+
+// Validate checks the configuration for errors
+func (c *Config) Validate() error {
+	for name, module := range c.AuthModules {
+		if module.Login == "" {
+			return fmt.Errorf("auth module %q: login is required", name)
+		}
+		if err := module.validatePassword(); err != nil {
+			return fmt.Errorf("auth module %q: %w", name, err)
+		}
+	}
+
+	names := make(map[string]bool)
+	for i, target := range c.Targets {
+		if target.Host == "" && len(target.Hosts) == 0 {
+			return fmt.Errorf("target #%d: host is required", i+1)
+		}
+		if target.Host != "" && len(target.Hosts) > 0 {
+			return fmt.Errorf("target %q: host and hosts are mutually exclusive", target.Name)
+		}
+		for _, host := range target.Hosts {
+			if host == "" {
+				return fmt.Errorf("target %q: hosts must not be empty", target.Name)
+			}
+		}
+		for _, address := range target.Addresses() {
+			if _, err := normalizeAddress(address); err != nil {
+				return fmt.Errorf("target %q: %w", target.Name, err)
+			}
+		}
+		if target.FailbackAfter < 0 {
+			return fmt.Errorf("target %q: failback_after must not be negative", target.Name)
+		}
+		if names[target.Name] {
+			return fmt.Errorf("target %q: duplicate target name", target.Name)
+		}
+		names[target.Name] = true
+
+		if target.AuthModule != "" && (target.Login != "" || target.Password != "" || target.PasswordFile != "") {
+			return fmt.Errorf("target %q: auth_module and login/password are mutually exclusive", target.Name)
+		}
+		if _, err := c.Credentials(target); err != nil {
+			return fmt.Errorf("target %q: %w", target.Name, err)
+		}
+		if target.Timeout <= 0 {
+			return fmt.Errorf("target %q: timeout must be positive", target.Name)
+		}
+		if target.Concurrency < 1 {
+			return fmt.Errorf("target %q: concurrency must be at least 1", target.Name)
+		}
+		if target.MaxSessions < 0 {
+			return fmt.Errorf("target %q: max_sessions must not be negative", target.Name)
+		}
+		if err := target.Retry.Validate(); err != nil {
+			return fmt.Errorf("target %q: %w", target.Name, err)
+		}
+		if _, err := target.TLSConfig.Build(); err != nil {
+			return fmt.Errorf("target %q: %w", target.Name, err)
+		}
+		for label := range target.Labels {
+			if !labelNameRE.MatchString(label) {
+				return fmt.Errorf("target %q: invalid label name %q", target.Name, label)
+			}
+			if reservedLabels[label] {
+				return fmt.Errorf("target %q: label %q is reserved", target.Name, label)
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateLabels checks that no target label is also a label of a metric,
+// which would keep the metric from being registered for the target
+func (c *Config) ValidateLabels(metrics map[string]MetricDefinition) error {
+	used := map[string]string{"controller": "version"}
+	for _, label := range versionLabels {
+		used[label] = "version"
+	}
+	for name, metric := range metrics {
+		for _, label := range metric.LabelSchema() {
+			used[label] = name
+		}
+	}
+	for _, target := range c.Targets {
+		for label := range target.Labels {
+			if metric, ok := used[label]; ok {
+				return fmt.Errorf("target %q: label %q is already a label of metric %q", target.Name, label, metric)
+			}
+		}
+	}
+	return nil
+}
+
+// Credentials resolves the login and password for a target
+func (c *Config) Credentials(target TargetConfig) (AuthModule, error) {
+	if target.AuthModule == "" {
+		if target.Login == "" || (target.Password == "" && target.PasswordFile == "") {
+			return AuthModule{}, fmt.Errorf("either auth_module or login and password are required")
+		}
+		auth := AuthModule{Login: target.Login, Password: target.Password, PasswordFile: target.PasswordFile}
+		return auth, auth.validatePassword()
+	}
+	module, ok := c.AuthModules[target.AuthModule]
+	if !ok {
+		return AuthModule{}, fmt.Errorf("unknown auth module %q", target.AuthModule)
+	}
+	return module, nil
+}
+
+// validatePassword checks that exactly one of password and password_file is
+// set and that the password file can be read
+func (a AuthModule) validatePassword() error {
+	switch {
+	case a.Password != "" && a.PasswordFile != "":
+		return fmt.Errorf("password and password_file are mutually exclusive")
+	case a.PasswordFile != "":
+		_, err := readPasswordFile(a.PasswordFile)
+		return err
+	case a.Password == "":
+		return fmt.Errorf("password is required unless password_file is set")
+	}
+	return nil
+}
+
+// readPasswordFile reads a password from a file, ignoring trailing newlines
+func readPasswordFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password file: %w", err)
+	}
+	password := strings.TrimRight(string(content), "\r\n")
+	if password == "" {
+		return "", fmt.Errorf("password file %s is empty", path)
+	}
+	return password, nil
+}
+
+// FindTarget returns the configured target with the given name
+func (c *Config) FindTarget(name string) (TargetConfig, bool) {
+	for _, target := range c.Targets {
+		if target.Name == name {
+			return target, true
+		}
+	}
+	return TargetConfig{}, false
+}
+
+// Addresses returns the management addresses of the target in order of preference
+func (t TargetConfig) Addresses() []string {
+	if len(t.Hosts) > 0 {
+		return t.Hosts
+	}
+	if t.Host == "" {
+		return nil
+	}
+	return []string{t.Host}
+}
+
+// normalizeAddresses rewrites valid addresses into their normalized form.
+// Invalid ones are kept as they are and reported by Validate.
+func (t *TargetConfig) normalizeAddresses() {
+	if address, err := normalizeAddress(t.Host); err == nil {
+		t.Host = address
+	}
+	for i, host := range t.Hosts {
+		if address, err := normalizeAddress(host); err == nil {
+			t.Hosts[i] = address
+		}
+	}
+}
+
+// ConstLabels returns the labels attached to every metric of the target
+func (t TargetConfig) ConstLabels() prometheus.Labels {
+	labels := prometheus.Labels{"target": t.Name}
+	for k, v := range t.Labels {
+		labels[k] = v
+	}
+	return labels
+}
+
+// Validate checks the retry settings for errors
+func (r RetryConfig) Validate() error {
+	switch {
+	case r.MaxRetries < 0:
+		return fmt.Errorf("retry: max_retries must not be negative")
+	case r.MaxRetries > 0 && r.Backoff <= 0:
+		return fmt.Errorf("retry: backoff must be positive")
+	case r.LoginFailureThreshold < 0:
+		return fmt.Errorf("retry: login_failure_threshold must not be negative")
+	case r.LoginFailureThreshold > 0 && r.LoginBackoff <= 0:
+		return fmt.Errorf("retry: login_backoff must be positive")
+	}
+	return nil
+}
+
+// Build creates a *tls.Config from the settings
+func (t TLSConfig) Build() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: t.InsecureSkipVerify,
+		ServerName:         t.ServerName,
+	}
+	if t.MinVersion != "" {
+		version, ok := tlsVersions[t.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown min_version %q", t.MinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+	if t.CAFile != "" {
+		caPEM, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", t.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if t.CertFile != "" || t.KeyFile != "" {
+		if t.CertFile == "" || t.KeyFile == "" {
+			return nil, fmt.Errorf("cert_file and key_file must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if len(t.PinnedSHA256) > 0 {
+		pins := make(map[string]bool, len(t.PinnedSHA256))
+		for _, pin := range t.PinnedSHA256 {
+			fingerprint := strings.ToLower(strings.ReplaceAll(pin, ":", ""))
+			if decoded, err := hex.DecodeString(fingerprint); err != nil || len(decoded) != sha256.Size {
+				return nil, fmt.Errorf("invalid SHA-256 fingerprint %q", pin)
+			}
+			pins[fingerprint] = true
+		}
+		// The pin replaces chain verification so self-signed certificates can be used
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return fmt.Errorf("no certificate presented")
+			}
+			sum := sha256.Sum256(state.PeerCertificates[0].Raw)
+			if !pins[hex.EncodeToString(sum[:])] {
+				return fmt.Errorf("certificate with SHA-256 fingerprint %x is not pinned", sum)
+			}
+			return nil
+		}
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,524 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// testTLSConfig accepts the self-signed certificates of httptest servers
+var testTLSConfig = TLSConfig{InsecureSkipVerify: true}
+
+// writeTestFile writes content to a file in a temporary directory
+func writeTestFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeTestFile(t, "config.yml", `
+auth_modules:
+  monitoring:
+    login: monitor
+    password: secret
+targets:
+  - name: msa1
+    host: msa1.example.com
+    auth_module: monitoring
+    timeout: 30s
+    labels:
+      datacenter: dc1
+    retry:
+      max_retries: 5
+  - hosts: [msa2.example.com, msa2-b.example.com]
+    login: admin
+    password: admin-secret
+    tls_config:
+      insecure_skip_verify: false
+      server_name: msa2.internal
+`)
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	cfg.ApplyDefaults(60 * time.Second)
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	if len(cfg.Targets) != 2 {
+		t.Fatalf("Expected 2 targets, got %d", len(cfg.Targets))
+	}
+
+	msa1 := cfg.Targets[0]
+	if msa1.Timeout != model.Duration(30*time.Second) {
+		t.Errorf("Expected timeout 30s, got %v", msa1.Timeout)
+	}
+	if msa1.Retry.MaxRetries != 5 || msa1.Retry.LoginFailureThreshold != DefaultRetryConfig.LoginFailureThreshold {
+		t.Errorf("Expected max_retries 5 with other retry defaults, got %+v", msa1.Retry)
+	}
+	if msa1.TLSConfig.InsecureSkipVerify {
+		t.Error("Expected certificate verification to be enabled by default")
+	}
+	auth, err := cfg.Credentials(msa1)
+	if err != nil {
+		t.Fatalf("Credentials failed: %v", err)
+	}
+	if auth.Login != "monitor" || auth.Password != "secret" {
+		t.Errorf("Unexpected credentials for msa1: %+v", auth)
+	}
+	labels := msa1.ConstLabels()
+	if labels["target"] != "msa1" || labels["datacenter"] != "dc1" {
+		t.Errorf("Unexpected const labels: %v", labels)
+	}
+
+	msa2 := cfg.Targets[1]
+	if msa2.Name != "msa2.example.com" {
+		t.Errorf("Expected name to default to host, got %s", msa2.Name)
+	}
+	if msa2.Timeout != model.Duration(60*time.Second) {
+		t.Errorf("Expected default timeout 60s, got %v", msa2.Timeout)
+	}
+	if msa2.Concurrency != defaultConcurrency {
+		t.Errorf("Expected default concurrency %d, got %d", defaultConcurrency, msa2.Concurrency)
+	}
+	if msa2.Retry != DefaultRetryConfig {
+		t.Errorf("Expected default retry settings, got %+v", msa2.Retry)
+	}
+	if msa2.TLSConfig.InsecureSkipVerify {
+		t.Error("Expected insecure_skip_verify to be false")
+	}
+	if addresses := msa2.Addresses(); len(addresses) != 2 || addresses[1] != "msa2-b.example.com" {
+		t.Errorf("Unexpected addresses: %v", addresses)
+	}
+	if msa2.FailbackAfter != model.Duration(defaultFailbackAfter) {
+		t.Errorf("Expected default failback_after, got %v", msa2.FailbackAfter)
+	}
+	if _, ok := cfg.FindTarget("msa2.example.com"); !ok {
+		t.Error("FindTarget did not find msa2.example.com")
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yml")); err == nil {
+			t.Error("Expected error for missing file")
+		}
+	})
+
+	t.Run("unknown field", func(t *testing.T) {
+		path := writeTestFile(t, "config.yml", `
+targets:
+  - host: msa1
+    hostname: typo
+`)
+		if _, err := LoadConfig(path); err == nil {
+			t.Error("Expected error for unknown field")
+		}
+	})
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        string
+		expectedError string
+	}{
+		{
+			name: "missing host",
+			config: `
+targets:
+  - login: a
+    password: b
+`,
+			expectedError: "host is required",
+		},
+		{
+			name: "password and password file",
+			config: `
+targets:
+  - host: msa1
+    login: a
+    password: b
+    password_file: /run/secrets/msa
+`,
+			expectedError: "password and password_file are mutually exclusive",
+		},
+		{
+			name: "missing password file",
+			config: `
+auth_modules:
+  default:
+    login: a
+    password_file: /nonexistent/msa-password
+targets:
+  - host: msa1
+    auth_module: default
+`,
+			expectedError: "failed to read password file",
+		},
+		{
+			name: "invalid address",
+			config: `
+targets:
+  - host: ftp://msa1
+    login: a
+    password: b
+`,
+			expectedError: `unsupported scheme "ftp"`,
+		},
+		{
+			name: "duplicate normalized names",
+			config: `
+targets:
+  - host: msa1
+    login: a
+    password: b
+  - host: https://msa1:443/
+    login: a
+    password: b
+`,
+			expectedError: "duplicate target name",
+		},
+		{
+			name: "duplicate names",
+			config: `
+targets:
+  - host: msa1
+    login: a
+    password: b
+  - host: msa1
+    login: a
+    password: b
+`,
+			expectedError: "duplicate target name",
+		},
+		{
+			name: "missing credentials",
+			config: `
+targets:
+  - host: msa1
+`,
+			expectedError: "either auth_module or login and password are required",
+		},
+		{
+			name: "unknown auth module",
+			config: `
+targets:
+  - host: msa1
+    auth_module: missing
+`,
+			expectedError: `unknown auth module "missing"`,
+		},
+		{
+			name: "auth module and credentials",
+			config: `
+auth_modules:
+  default:
+    login: a
+    password: b
+targets:
+  - host: msa1
+    auth_module: default
+    login: a
+`,
+			expectedError: "mutually exclusive",
+		},
+		{
+			name: "auth module without password",
+			config: `
+auth_modules:
+  default:
+    login: a
+`,
+			expectedError: `auth module "default": password is required`,
+		},
+		{
+			name: "invalid label name",
+			config: `
+targets:
+  - host: msa1
+    login: a
+    password: b
+    labels:
+      data-center: dc1
+`,
+			expectedError: `invalid label name "data-center"`,
+		},
+		{
+			name: "reserved label",
+			config: `
+targets:
+  - host: msa1
+    login: a
+    password: b
+    labels:
+      target: other
+`,
+			expectedError: `label "target" is reserved`,
+		},
+		{
+			name: "negative concurrency",
+			config: `
+targets:
+  - host: msa1
+    login: a
+    password: b
+    concurrency: -2
+`,
+			expectedError: "concurrency must be at least 1",
+		},
+		{
+			name: "negative max sessions",
+			config: `
+targets:
+  - host: msa1
+    login: a
+    password: b
+    max_sessions: -1
+`,
+			expectedError: "max_sessions must not be negative",
+		},
+		{
+			name: "host and hosts",
+			config: `
+targets:
+  - host: msa1
+    hosts: [msa1-a, msa1-b]
+    login: a
+    password: b
+`,
+			expectedError: "host and hosts are mutually exclusive",
+		},
+		{
+			name: "negative retries",
+			config: `
+targets:
+  - host: msa1
+    login: a
+    password: b
+    retry:
+      max_retries: -1
+`,
+			expectedError: "max_retries must not be negative",
+		},
+		{
+			name: "zero login backoff",
+			config: `
+targets:
+  - host: msa1
+    login: a
+    password: b
+    retry:
+      login_backoff: 0s
+`,
+			expectedError: "login_backoff must be positive",
+		},
+		{
+			name: "missing CA file",
+			config: `
+targets:
+  - host: msa1
+    login: a
+    password: b
+    tls_config:
+      ca_file: /nonexistent/ca.pem
+`,
+			expectedError: "failed to read CA file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadConfig(writeTestFile(t, "config.yml", tt.config))
+			if err != nil {
+				t.Fatalf("LoadConfig failed: %v", err)
+			}
+			cfg.ApplyDefaults(60 * time.Second)
+			err = cfg.Validate()
+			if err == nil {
+				t.Fatal("Expected validation error")
+			}
+			if !strings.Contains(err.Error(), tt.expectedError) {
+				t.Errorf("Expected error containing %q, got %q", tt.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestTLSConfigBuild(t *testing.T) {
+	t.Run("invalid CA file", func(t *testing.T) {
+		path := writeTestFile(t, "ca.pem", "not a certificate")
+		if _, err := (TLSConfig{CAFile: path}).Build(); err == nil {
+			t.Error("Expected error for CA file without certificates")
+		}
+	})
+
+	t.Run("server name", func(t *testing.T) {
+		tlsConfig, err := TLSConfig{ServerName: "msa.internal"}.Build()
+		if err != nil {
+			t.Fatalf("Build failed: %v", err)
+		}
+		if tlsConfig.ServerName != "msa.internal" {
+			t.Errorf("Expected server name msa.internal, got %s", tlsConfig.ServerName)
+		}
+		if tlsConfig.InsecureSkipVerify {
+			t.Error("Expected certificate verification to be enabled")
+		}
+	})
+
+	t.Run("invalid settings", func(t *testing.T) {
+		for _, tc := range []TLSConfig{
+			{MinVersion: "TLS14"},
+			{PinnedSHA256: []string{"abcd"}},
+			{CertFile: "/etc/msa/client.pem"},
+		} {
+			if _, err := tc.Build(); err == nil {
+				t.Errorf("Expected error for %+v", tc)
+			}
+		}
+	})
+
+	t.Run("min version", func(t *testing.T) {
+		tlsConfig, err := TLSConfig{MinVersion: "TLS13"}.Build()
+		if err != nil {
+			t.Fatalf("Build failed: %v", err)
+		}
+		if tlsConfig.MinVersion != tls.VersionTLS13 {
+			t.Errorf("Expected TLS 1.3, got %x", tlsConfig.MinVersion)
+		}
+	})
+}
+
+// tlsGet connects to server with the given settings and reports the error
+func tlsGet(t *testing.T, server *httptest.Server, settings TLSConfig) error {
+	t.Helper()
+	tlsConfig, err := settings.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// writeTestCertificate writes a self-signed client certificate and its key
+func writeTestCertificate(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "msa_exporter"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = writeTestFile(t, "client.pem", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+	keyFile = writeTestFile(t, "client-key.pem", string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})))
+	return certFile, keyFile
+}
+
+func TestTLSConnections(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	serverCert := server.Certificate()
+
+	t.Run("verification is enabled by default", func(t *testing.T) {
+		if err := tlsGet(t, server, DefaultTLSConfig); err == nil {
+			t.Error("Expected self-signed certificate to be rejected")
+		}
+	})
+
+	t.Run("CA file", func(t *testing.T) {
+		caFile := writeTestFile(t, "ca.pem", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: serverCert.Raw})))
+		if err := tlsGet(t, server, TLSConfig{CAFile: caFile, ServerName: "example.com"}); err != nil {
+			t.Errorf("Expected certificate signed by the CA to be accepted: %v", err)
+		}
+	})
+
+	t.Run("pinned certificate", func(t *testing.T) {
+		sum := sha256.Sum256(serverCert.Raw)
+		pin := strings.ToUpper(hex.EncodeToString(sum[:]))
+		if err := tlsGet(t, server, TLSConfig{PinnedSHA256: []string{pin}}); err != nil {
+			t.Errorf("Expected pinned certificate to be accepted: %v", err)
+		}
+		other := strings.Repeat("ab", sha256.Size)
+		if err := tlsGet(t, server, TLSConfig{PinnedSHA256: []string{other}}); err == nil || !strings.Contains(err.Error(), "is not pinned") {
+			t.Errorf("Expected certificate that is not pinned to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("client certificate", func(t *testing.T) {
+		mtls := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		mtls.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+		mtls.StartTLS()
+		defer mtls.Close()
+
+		if err := tlsGet(t, mtls, testTLSConfig); err == nil {
+			t.Error("Expected connection without client certificate to fail")
+		}
+		certFile, keyFile := writeTestCertificate(t)
+		if err := tlsGet(t, mtls, TLSConfig{InsecureSkipVerify: true, CertFile: certFile, KeyFile: keyFile}); err != nil {
+			t.Errorf("Expected connection with client certificate to succeed: %v", err)
+		}
+	})
+}
+
+func TestReadPasswordFile(t *testing.T) {
+	password, err := readPasswordFile(writeTestFile(t, "password", "s3cret\r\n"))
+	if err != nil {
+		t.Fatalf("readPasswordFile failed: %v", err)
+	}
+	if password != "s3cret" {
+		t.Errorf("Expected trailing newline to be dropped, got %q", password)
+	}
+
+	if _, err := readPasswordFile(writeTestFile(t, "empty", "\n")); err == nil {
+		t.Error("Expected error for empty password file")
+	}
+
+	cfg := &Config{AuthModules: map[string]AuthModule{}}
+	auth, err := cfg.Credentials(TargetConfig{Login: "monitor", PasswordFile: writeTestFile(t, "password", "s3cret")})
+	if err != nil {
+		t.Fatalf("Credentials failed: %v", err)
+	}
+	if auth.Password != "" || auth.PasswordFile == "" {
+		t.Errorf("Expected the password file to be passed on unread, got %+v", auth)
+	}
+}
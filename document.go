@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// errInvalidResponse is returned for responses that are not valid XML
+var errInvalidResponse = errors.New("invalid response")
+
+// document is a parsed API response. Objects at any depth are indexed by
+// name and basetype in document order, parents before their children,
+// so metric sources select them without walking the tree. The parent of
+// each object is the object it is nested in or, for flat responses, the
+// group named by a COMP element.
+type document struct {
+	Objects    []Object
+	byName     map[string][]*Object
+	byBasetype map[string][]*Object
+	byOID      map[string]*Object
+	parents    map[*Object]*Object
+}
+
+// component is a COMP element linking the object with oid part to the
+// object with oid group
+type component struct {
+	group, part string
+}
+
+// newDocument indexes the given top-level objects
+func newDocument(objects []Object, components ...component) *document {
+	d := &document{
+		Objects:    objects,
+		byName:     make(map[string][]*Object),
+		byBasetype: make(map[string][]*Object),
+		byOID:      make(map[string]*Object),
+		parents:    make(map[*Object]*Object),
+	}
+	d.index(objects, nil)
+	for _, c := range components {
+		group, part := d.byOID[c.group], d.byOID[c.part]
+		if group != nil && part != nil && group != part && d.parents[part] == nil {
+			d.parents[part] = group
+		}
+	}
+	return d
+}
+
+func (d *document) index(objects []Object, parent *Object) {
+	for i := range objects {
+		obj := &objects[i]
+		d.byName[obj.Name] = append(d.byName[obj.Name], obj)
+		if obj.Basetype != "" {
+			d.byBasetype[obj.Basetype] = append(d.byBasetype[obj.Basetype], obj)
+		}
+		if obj.OID != "" {
+			d.byOID[obj.OID] = obj
+		}
+		if parent != nil {
+			d.parents[obj] = parent
+		}
+		d.index(obj.Objects, obj)
+	}
+}
+
+// named returns the objects with the given name attribute at any depth
+func (d *document) named(name string) []*Object {
+	return d.byName[name]
+}
+
+// withBasetype returns the objects with the given basetype attribute
+func (d *document) withBasetype(basetype string) []*Object {
+	return d.byBasetype[basetype]
+}
+
+// selectObjects returns the objects matching a name, a basetype or both;
+// an empty selector matches any value
+func (d *document) selectObjects(name, basetype string) []*Object {
+	switch {
+	case basetype == "":
+		return d.named(name)
+	case name == "":
+		return d.withBasetype(basetype)
+	}
+	var result []*Object
+	for _, obj := range d.withBasetype(basetype) {
+		if obj.matches(name, basetype) {
+			result = append(result, obj)
+		}
+	}
+	return result
+}
+
+// matches reports whether an object has the given name and basetype;
+// an empty selector matches any value
+func (o *Object) matches(name, basetype string) bool {
+	return (name == "" || o.Name == name) && (basetype == "" || o.Basetype == basetype)
+}
+
+// parent returns the object an object of the document belongs to
+func (d *document) parent(obj *Object) (*Object, bool) {
+	parent, ok := d.parents[obj]
+	return parent, ok
+}
+
+// ancestor returns the nearest ancestor of an object matching a name,
+// a basetype or both
+func (d *document) ancestor(obj *Object, name, basetype string) (*Object, bool) {
+	for parent, ok := d.parent(obj); ok; parent, ok = d.parent(parent) {
+		if parent.matches(name, basetype) {
+			return parent, true
+		}
+	}
+	return nil, false
+}
+
+// decodeDocument parses a response while it is read, so large responses
+// are never buffered as a whole. It builds the same objects as
+// xml.Unmarshal into a Response without the cost of reflection.
+func decodeDocument(r io.Reader) (*document, error) {
+	decoder := xml.NewDecoder(r)
+	var (
+		objects    []Object
+		components []component
+		stack      []Object
+		prop       *Property
+		value      strings.Builder
+		root       bool
+	)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			if !root {
+				return nil, fmt.Errorf("%w: no XML element found", errInvalidResponse)
+			}
+			break
+		}
+		var syntaxErr *xml.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return nil, fmt.Errorf("%w: %w", errInvalidResponse, err)
+		}
+		if err != nil {
+			// The body could not be read
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			root = true
+			switch {
+			case t.Name.Local == "OBJECT":
+				stack = append(stack, newObject(t))
+			case t.Name.Local == "COMP":
+				components = append(components, component{group: attr(t, "G"), part: attr(t, "P")})
+			case t.Name.Local == "PROPERTY" && len(stack) > 0 && prop == nil:
+				prop = &Property{Name: attr(t, "name")}
+				value.Reset()
+			}
+		case xml.CharData:
+			if prop != nil {
+				value.Write(t)
+			}
+		case xml.EndElement:
+			switch {
+			case t.Name.Local == "PROPERTY" && prop != nil:
+				prop.Value = value.String()
+				top := &stack[len(stack)-1]
+				top.Properties = append(top.Properties, *prop)
+				prop = nil
+			case t.Name.Local == "OBJECT" && len(stack) > 0:
+				obj := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				if len(stack) > 0 {
+					parent := &stack[len(stack)-1]
+					parent.Objects = append(parent.Objects, obj)
+				} else {
+					objects = append(objects, obj)
+				}
+			}
+		}
+	}
+	return newDocument(objects, components...), nil
+}
+
+// newObject creates an object from the attributes of its element
+func newObject(element xml.StartElement) Object {
+	var obj Object
+	for _, a := range element.Attr {
+		switch {
+		case a.Name.Local == "name":
+			obj.Name = a.Value
+		case a.Name.Local == "basetype":
+			obj.Basetype = a.Value
+		case a.Name.Local == "oid":
+			obj.OID = a.Value
+		default:
+			obj.Attrs = append(obj.Attrs, a)
+		}
+	}
+	return obj
+}
+
+// attr returns the value of the named attribute of an element
+func attr(element xml.StartElement, name string) string {
+	for _, a := range element.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+const testDocument = `<?xml version="1.0" encoding="UTF-8"?>
+<RESPONSE VERSION="L100">
+	<OBJECT basetype="pool-statistics" name="pool-statistics" oid="1" format="pairs">
+		<PROPERTY name="pool" type="string">A</PROPERTY>
+		<PROPERTY name="serial-number">00c0ff</PROPERTY>
+		<OBJECT basetype="tier-statistics" name="tier-statistics" oid="2">
+			<PROPERTY name="tier">Performance</PROPERTY>
+			<PROPERTY name="number-of-reads">10 &amp; more</PROPERTY>
+		</OBJECT>
+		<OBJECT basetype="tier-statistics" name="tier-statistics" oid="3">
+			<PROPERTY name="tier">Archive</PROPERTY>
+			<PROPERTY name="number-of-reads"></PROPERTY>
+		</OBJECT>
+	</OBJECT>
+	<OBJECT basetype="status" name="status" oid="4">
+		<PROPERTY name="response-type">Success</PROPERTY>
+	</OBJECT>
+	<COMP G="1" P="2"/>
+</RESPONSE>`
+
+// parseDocument parses a response held in memory
+func parseDocument(data []byte) (*document, error) {
+	return decodeDocument(bytes.NewReader(data))
+}
+
+// findObjects searches objects by name the way selectors worked before
+// the document index, as the reference the index is checked against
+func findObjects(objects []Object, name string) []Object {
+	var result []Object
+	for _, obj := range objects {
+		if obj.Name == name {
+			result = append(result, obj)
+		}
+		result = append(result, findObjects(obj.Objects, name)...)
+	}
+	return result
+}
+
+func TestDecodeDocument(t *testing.T) {
+	doc, err := parseDocument([]byte(testDocument))
+	if err != nil {
+		t.Fatalf("parseDocument failed: %v", err)
+	}
+
+	// The streaming decoder builds the same objects as xml.Unmarshal
+	var expected Response
+	if err := xml.Unmarshal([]byte(testDocument), &expected); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(doc.Objects, expected.Objects) {
+		t.Errorf("Decoded objects differ from xml.Unmarshal:\n%+v\n%+v", doc.Objects, expected.Objects)
+	}
+
+	tiers := doc.named("tier-statistics")
+	if len(tiers) != 2 || tiers[0].Properties[0].Value != "Performance" || tiers[1].Properties[0].Value != "Archive" {
+		t.Errorf("Expected both tiers in document order, got %+v", tiers)
+	}
+	var indexed []Object
+	for _, tier := range tiers {
+		indexed = append(indexed, *tier)
+	}
+	if !reflect.DeepEqual(indexed, findObjects(doc.Objects, "tier-statistics")) {
+		t.Error("Index differs from findObjects")
+	}
+	if got := doc.withBasetype("status"); len(got) != 1 || got[0].Name != "status" {
+		t.Errorf("Expected status object by basetype, got %+v", got)
+	}
+	if got := doc.named("missing"); len(got) != 0 {
+		t.Errorf("Expected no objects, got %+v", got)
+	}
+
+	// All attributes are kept
+	pool := &doc.Objects[0]
+	if pool.OID != "1" || pool.Basetype != "pool-statistics" {
+		t.Errorf("Expected oid and basetype of the pool, got %+v", pool)
+	}
+	if len(pool.Attrs) != 1 || pool.Attrs[0].Name.Local != "format" || pool.Attrs[0].Value != "pairs" {
+		t.Errorf("Expected format attribute, got %+v", pool.Attrs)
+	}
+	if obj := doc.byOID["3"]; obj != tiers[1] {
+		t.Errorf("Expected tier with oid 3, got %+v", obj)
+	}
+
+	// Nested objects belong to the object containing them
+	for _, tier := range tiers {
+		if parent, ok := doc.parent(tier); !ok || parent != pool {
+			t.Errorf("Expected pool as parent of tier %s, got %+v", tier.OID, parent)
+		}
+	}
+	if parent, ok := doc.parent(pool); ok {
+		t.Errorf("Expected top-level pool without parent, got %+v", parent)
+	}
+}
+
+func TestDocumentComponents(t *testing.T) {
+	// Flat responses link objects through COMP elements
+	doc, err := parseDocument([]byte(`<RESPONSE>
+		<OBJECT basetype="enclosures" name="enclosure" oid="1"><PROPERTY name="enclosure-id">0</PROPERTY></OBJECT>
+		<OBJECT basetype="power-supplies" name="power-supplies" oid="2"><PROPERTY name="name">PSU 1</PROPERTY></OBJECT>
+		<OBJECT basetype="power-supplies" name="power-supplies" oid="3"><PROPERTY name="name">PSU 2</PROPERTY></OBJECT>
+		<OBJECT basetype="status" name="status" oid="4"/>
+		<COMP G="0" P="1"/>
+		<COMP G="1" P="2"/>
+		<COMP G="1" P="3"/>
+		<COMP G="9" P="4"/>
+	</RESPONSE>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enclosure := doc.byOID["1"]
+	for _, psu := range doc.withBasetype("power-supplies") {
+		if parent, ok := doc.parent(psu); !ok || parent != enclosure {
+			t.Errorf("Expected enclosure as parent of %s, got %+v", psu.OID, parent)
+		}
+	}
+	for _, oid := range []string{"1", "4"} {
+		if parent, ok := doc.parent(doc.byOID[oid]); ok {
+			t.Errorf("Expected no parent for oid %s, got %+v", oid, parent)
+		}
+	}
+}
+
+func TestDocumentAncestor(t *testing.T) {
+	doc, err := parseDocument([]byte(`<RESPONSE>
+		<OBJECT basetype="controllers" name="controller" oid="1">
+			<OBJECT basetype="port" name="ports" oid="2">
+				<OBJECT basetype="fc-port" name="port-details" oid="3"/>
+			</OBJECT>
+		</OBJECT>
+	</RESPONSE>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	details := doc.byOID["3"]
+	tests := []struct {
+		name, basetype string
+		expected       string
+	}{
+		{"", "port", "2"},
+		{"controller", "", "1"},
+		{"controller", "controllers", "1"},
+		{"", "", "2"},
+		{"ports", "controllers", ""},
+	}
+	for _, tt := range tests {
+		ancestor, ok := doc.ancestor(details, tt.name, tt.basetype)
+		if tt.expected == "" {
+			if ok {
+				t.Errorf("ancestor(%q, %q) = %s, expected none", tt.name, tt.basetype, ancestor.OID)
+			}
+			continue
+		}
+		if !ok || ancestor.OID != tt.expected {
+			t.Errorf("ancestor(%q, %q) = %+v, expected oid %s", tt.name, tt.basetype, ancestor, tt.expected)
+		}
+	}
+}
+
+func TestSelectObjects(t *testing.T) {
+	doc, err := parseDocument([]byte(`<RESPONSE>
+		<OBJECT basetype="pools" name="pool" oid="1"/>
+		<OBJECT basetype="pools" name="pools" oid="2"/>
+		<OBJECT basetype="disk-groups" name="pool" oid="3"/>
+	</RESPONSE>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, basetype string
+		expected       []string
+	}{
+		{"pool", "", []string{"1", "3"}},
+		{"", "pools", []string{"1", "2"}},
+		{"pool", "pools", []string{"1"}},
+		{"pools", "disk-groups", nil},
+	}
+	for _, tt := range tests {
+		var oids []string
+		for _, obj := range doc.selectObjects(tt.name, tt.basetype) {
+			oids = append(oids, obj.OID)
+		}
+		if !reflect.DeepEqual(oids, tt.expected) {
+			t.Errorf("selectObjects(%q, %q) = %v, expected %v", tt.name, tt.basetype, oids, tt.expected)
+		}
+	}
+}
+
+func TestDecodeDocumentErrors(t *testing.T) {
+	for _, body := range []string{"", "invalid xml", `<RESPONSE><OBJECT name="a">`} {
+		if _, err := parseDocument([]byte(body)); !errors.Is(err, errInvalidResponse) {
+			t.Errorf("Expected invalid response error for %q, got %v", body, err)
+		}
+	}
+
+	// A body that cannot be read is not reported as invalid XML
+	readErr := errors.New("connection reset by peer")
+	_, err := decodeDocument(iotest.ErrReader(readErr))
+	if !errors.Is(err, readErr) || errors.Is(err, errInvalidResponse) {
+		t.Errorf("Expected read error, got %v", err)
+	}
+}
+
+// diskStatisticsResponse generates a disk-statistics response for n drives
+func diskStatisticsResponse(n int) []byte {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?><RESPONSE VERSION="L100">`)
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, `<OBJECT basetype="disk-statistics" name="disk-statistics" oid="%d" format="rows">`, i+1)
+		fmt.Fprintf(&b, `<PROPERTY name="durable-id" type="string">disk_01.%02d</PROPERTY>`, i)
+		fmt.Fprintf(&b, `<PROPERTY name="serial-number" type="string">SN%06d</PROPERTY>`, i)
+		for j := 0; j < 40; j++ {
+			fmt.Fprintf(&b, `<PROPERTY name="counter-%d" type="uint64">%d</PROPERTY>`, j, i*j)
+		}
+		b.WriteString(`</OBJECT>`)
+	}
+	b.WriteString(`<OBJECT basetype="status" name="status" oid="0"><PROPERTY name="response-type">Success</PROPERTY></OBJECT></RESPONSE>`)
+	return []byte(b.String())
+}
+
+// benchmarkSources is the number of metric sources reading disk-statistics
+const benchmarkSources = 16
+
+func BenchmarkDecode(b *testing.B) {
+	data := diskStatisticsResponse(500)
+	b.Run("unmarshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			var response Response
+			if err := xml.Unmarshal(data, &response); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("document", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			if _, err := parseDocument(data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkMetricSources compares decoding the response for every metric
+// source with decoding it once into an indexed document
+func BenchmarkMetricSources(b *testing.B) {
+	data := diskStatisticsResponse(500)
+	b.Run("unmarshal_per_source", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			for range benchmarkSources {
+				var response Response
+				if err := xml.Unmarshal(data, &response); err != nil {
+					b.Fatal(err)
+				}
+				if len(findObjects(response.Objects, "disk-statistics")) != 500 {
+					b.Fatal("missing objects")
+				}
+			}
+		}
+	})
+	b.Run("document_cache", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			doc, err := parseDocument(data)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for range benchmarkSources {
+				if len(doc.named("disk-statistics")) != 500 {
+					b.Fatal("missing objects")
+				}
+			}
+		}
+	})
+}
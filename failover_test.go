@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+)
+
+// newFailoverTarget returns a target with the given controller addresses
+func newFailoverTarget(name string, failback time.Duration, hosts ...string) TargetConfig {
+	return TargetConfig{
+		Name:          name,
+		Hosts:         hosts,
+		FailbackAfter: model.Duration(failback),
+		Timeout:       model.Duration(10 * time.Second),
+		TLSConfig:     testTLSConfig,
+	}
+}
+
+func TestFailoverOnConnectFailure(t *testing.T) {
+	var loginsA, logoutsA, loginsB, logoutsB atomic.Int32
+	controllerA := newSessionTestServer(t, &loginsA, &logoutsA)
+	controllerB := newSessionTestServer(t, &loginsB, &logoutsB)
+	hostA, hostB := controllerA.URL[8:], controllerB.URL[8:]
+
+	pool := newClientPool()
+	defer pool.Close(t.Context())
+	defer deleteTargetMetrics("failover-test")
+	target := newFailoverTarget("failover-test", time.Hour, hostA, hostB)
+	pool.SetTargets([]TargetConfig{target})
+	client, err := pool.Client("failover-test", target, AuthModule{Login: "admin", Password: "secret"})
+	if err != nil {
+		t.Fatalf("Client failed: %v", err)
+	}
+
+	if _, err := client.GetDocument(t.Context(), "system"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if controller := client.SessionState().Controller; controller != hostA {
+		t.Errorf("Expected preferred controller %s, got %s", hostA, controller)
+	}
+
+	// Controller A goes away in the middle of the session
+	controllerA.Close()
+	if _, err := client.GetDocument(t.Context(), "system"); err != nil {
+		t.Fatalf("Get did not fail over: %v", err)
+	}
+	if controller := client.SessionState().Controller; controller != hostB {
+		t.Errorf("Expected partner controller %s, got %s", hostB, controller)
+	}
+	if v := testutil.ToFloat64(activeController.WithLabelValues("failover-test", hostB)); v != 1 {
+		t.Errorf("Expected %s to be reported active, got %v", hostB, v)
+	}
+	if v := testutil.ToFloat64(activeController.WithLabelValues("failover-test", hostA)); v != 0 {
+		t.Errorf("Expected %s to be reported inactive, got %v", hostA, v)
+	}
+}
+
+func TestFailoverOnLoginFailureAndFailback(t *testing.T) {
+	var down atomic.Bool
+	var loginsB, logoutsB atomic.Int32
+	down.Store(true)
+	controllerA := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path != "/api/exit" {
+			_, _ = w.Write([]byte(`<RESPONSE><OBJECT name="status"><PROPERTY name="response">key-a</PROPERTY></OBJECT></RESPONSE>`))
+		}
+	}))
+	defer controllerA.Close()
+	controllerB := newSessionTestServer(t, &loginsB, &logoutsB)
+	hostA, hostB := controllerA.URL[8:], controllerB.URL[8:]
+
+	pool := newClientPool()
+	defer pool.Close(t.Context())
+	defer deleteTargetMetrics("failback-test")
+	client, err := pool.Client("failback-test", newFailoverTarget("failback-test", 50*time.Millisecond, hostA, hostB), AuthModule{Login: "admin", Password: "secret"})
+	if err != nil {
+		t.Fatalf("Client failed: %v", err)
+	}
+
+	if _, err := client.GetDocument(t.Context(), "system"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if controller := client.SessionState().Controller; controller != hostB {
+		t.Fatalf("Expected failover to %s, got %s", hostB, controller)
+	}
+
+	// Controller A is back, the client fails back once failback_after has passed
+	down.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	if _, err := client.GetDocument(t.Context(), "system"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if controller := client.SessionState().Controller; controller != hostA {
+		t.Errorf("Expected failback to %s, got %s", hostA, controller)
+	}
+	if logoutsB.Load() != 1 {
+		t.Errorf("Expected the session on %s to be logged out, got %d logouts", hostB, logoutsB.Load())
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+)
+
+// defaultConcurrency is the number of API paths fetched in parallel per target
+const defaultConcurrency = 4
+
+// fetchResult is the parsed response to a single API path
+type fetchResult struct {
+	path string
+	doc  *document
+	err  error
+}
+
+// errFetchTimeout is returned for paths that did not complete before the scrape deadline
+var errFetchTimeout = errors.New("scrape timeout exceeded")
+
+// metricPaths returns the distinct API paths needed by the metric definitions, sorted
+func metricPaths(metrics map[string]MetricDefinition) []string {
+	var paths []string
+	for _, metricDef := range metrics {
+		for _, source := range metricDef.Sources {
+			paths = append(paths, source.Path)
+		}
+	}
+	paths = uniquePaths(paths)
+	sort.Strings(paths)
+	return paths
+}
+
+// uniquePaths removes duplicate paths, keeping the first occurrence
+func uniquePaths(paths []string) []string {
+	seen := make(map[string]bool, len(paths))
+	unique := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if !seen[path] {
+			seen[path] = true
+			unique = append(unique, path)
+		}
+	}
+	return unique
+}
+
+// fetchPaths fetches and parses the given API paths with at most workers
+// requests in flight, so every path is decoded once per scrape.
+// Requests still running when timeout expires or ctx is cancelled are aborted;
+// paths that have not completed get errFetchTimeout or the cancellation error.
+func fetchPaths(ctx context.Context, client *MSAClient, paths []string, workers int, timeout time.Duration) map[string]fetchResult {
+	paths = uniquePaths(paths)
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(paths) {
+		workers = len(paths)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	jobs := make(chan string, len(paths))
+	for _, path := range paths {
+		jobs <- path
+	}
+	close(jobs)
+
+	// Buffered so workers never block once the deadline has passed
+	results := make(chan fetchResult, len(paths))
+	for i := 0; i < workers; i++ {
+		go func() {
+			for path := range jobs {
+				if ctx.Err() != nil {
+					results <- fetchResult{path: path, err: fetchError(ctx)}
+					continue
+				}
+				doc, err := client.GetDocument(ctx, path)
+				if err != nil && ctx.Err() != nil {
+					// The request was aborted by the deadline or cancellation
+					err = fetchError(ctx)
+				}
+				results <- fetchResult{path: path, doc: doc, err: err}
+			}
+		}()
+	}
+
+	fetched := make(map[string]fetchResult, len(paths))
+	for len(fetched) < len(paths) {
+		select {
+		case result := <-results:
+			fetched[result.path] = result
+		case <-ctx.Done():
+			for _, path := range paths {
+				if _, ok := fetched[path]; !ok {
+					fetched[path] = fetchResult{path: path, err: fetchError(ctx)}
+				}
+			}
+		}
+	}
+	return fetched
+}
+
+// fetchError returns the error for paths not fetched before ctx was done
+func fetchError(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return errFetchTimeout
+	}
+	return ctx.Err()
+}
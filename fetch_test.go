@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMetricPaths(t *testing.T) {
+	paths := metricPaths(getMetrics())
+
+	for i := 1; i < len(paths); i++ {
+		if paths[i-1] >= paths[i] {
+			t.Fatalf("Paths are not sorted and unique: %v", paths)
+		}
+	}
+	for _, expected := range []string{"disk-statistics", "disks", "pool-statistics", "system"} {
+		found := false
+		for _, path := range paths {
+			if path == expected {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected path %s in %v", expected, paths)
+		}
+	}
+}
+
+// newFetchTestServer returns a mock array that delays show requests
+func newFetchTestServer(t *testing.T, delay time.Duration, inFlight, maxInFlight *atomic.Int32) *httptest.Server {
+	t.Helper()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/login/") {
+			_, _ = w.Write([]byte(`<RESPONSE><OBJECT name="status"><PROPERTY name="response">key</PROPERTY></OBJECT></RESPONSE>`))
+			return
+		}
+		if r.URL.Path == "/api/show/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		n := inFlight.Add(1)
+		for {
+			max := maxInFlight.Load()
+			if n <= max || maxInFlight.CompareAndSwap(max, n) {
+				break
+			}
+		}
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+		}
+		inFlight.Add(-1)
+		_, _ = w.Write([]byte(`<RESPONSE><OBJECT name="` + strings.TrimPrefix(r.URL.Path, "/api/show/") + `"/></RESPONSE>`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFetchPaths(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	server := newFetchTestServer(t, 50*time.Millisecond, &inFlight, &maxInFlight)
+	client := newMSAClient(server.URL[8:], "user", "pass", 10*time.Second, &tls.Config{InsecureSkipVerify: true})
+
+	paths := []string{"a", "b", "c", "d", "e", "f", "broken", "a"}
+
+	start := time.Now()
+	results := fetchPaths(t.Context(), client, paths, 3, 10*time.Second)
+	elapsed := time.Since(start)
+
+	if len(results) != 7 {
+		t.Fatalf("Expected 7 distinct results, got %d", len(results))
+	}
+	if maxInFlight.Load() > 3 {
+		t.Errorf("Expected at most 3 requests in flight, got %d", maxInFlight.Load())
+	}
+	if maxInFlight.Load() < 2 {
+		t.Errorf("Expected requests to run in parallel, max in flight %d", maxInFlight.Load())
+	}
+	if elapsed >= 6*50*time.Millisecond {
+		t.Errorf("Parallel fetch took %v, expected less than sequential", elapsed)
+	}
+	if results["broken"].err == nil {
+		t.Error("Expected error for broken path")
+	}
+	if doc := results["c"].doc; doc == nil || len(doc.named("c")) != 1 {
+		t.Errorf("Expected object c in the document for path c, got %+v", doc)
+	}
+}
+
+func TestFetchPathsTimeout(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	server := newFetchTestServer(t, 300*time.Millisecond, &inFlight, &maxInFlight)
+	client := newMSAClient(server.URL[8:], "user", "pass", 10*time.Second, &tls.Config{InsecureSkipVerify: true})
+
+	start := time.Now()
+	results := fetchPaths(t.Context(), client, []string{"a", "b", "c", "d"}, 1, 100*time.Millisecond)
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("fetchPaths did not respect the timeout, took %v", elapsed)
+	}
+
+	if len(results) != 4 {
+		t.Fatalf("Expected 4 results, got %d", len(results))
+	}
+	for path, result := range results {
+		if !errors.Is(result.err, errFetchTimeout) {
+			t.Errorf("Expected timeout error for %s, got %v", path, result.err)
+		}
+	}
+}
+
+func TestFetchPathsCancel(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	server := newFetchTestServer(t, 5*time.Second, &inFlight, &maxInFlight)
+	client := newMSAClient(server.URL[8:], "user", "pass", 10*time.Second, &tls.Config{InsecureSkipVerify: true})
+
+	ctx, cancel := context.WithCancel(t.Context())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	results := fetchPaths(ctx, client, []string{"a", "b"}, 2, 10*time.Second)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("fetchPaths was not cancelled, took %v", elapsed)
+	}
+	for path, result := range results {
+		if !errors.Is(result.err, context.Canceled) {
+			t.Errorf("Expected cancellation error for %s, got %v", path, result.err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// ObjectFilter keeps the objects whose property satisfies exactly one
+// predicate: equals, not_equals, regex or exists. Regular expressions
+// are anchored at both ends like Prometheus relabeling.
+type ObjectFilter struct {
+	Property  string  `yaml:"property"`
+	Equals    *string `yaml:"equals"`
+	NotEquals *string `yaml:"not_equals"`
+	Regex     string  `yaml:"regex"`
+	Exists    *bool   `yaml:"exists"`
+
+	re *regexp.Regexp
+}
+
+// compile checks the filter and compiles its regular expression
+func (f *ObjectFilter) compile() error {
+	if f.Property == "" {
+		return fmt.Errorf("property is required")
+	}
+	predicates := 0
+	for _, set := range []bool{f.Equals != nil, f.NotEquals != nil, f.Regex != "", f.Exists != nil} {
+		if set {
+			predicates++
+		}
+	}
+	if predicates != 1 {
+		return fmt.Errorf("exactly one of equals, not_equals, regex or exists is required for property %q", f.Property)
+	}
+	if f.Regex != "" {
+		re, err := regexp.Compile("^(?:" + f.Regex + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid regex for property %q: %w", f.Property, err)
+		}
+		f.re = re
+	}
+	return nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler. Filters are compiled as they
+// are decoded, so every copy of a definition carries its expressions.
+func (f *ObjectFilter) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain ObjectFilter
+	if err := unmarshal((*plain)(f)); err != nil {
+		return err
+	}
+	if err := f.compile(); err != nil {
+		return fmt.Errorf("invalid filter: %w", err)
+	}
+	return nil
+}
+
+// match reports whether an object passes the filter. A missing property
+// only passes not_equals and exists: false.
+func (f *ObjectFilter) match(obj Object) bool {
+	value, ok := obj.Property(f.Property)
+	switch {
+	case f.Exists != nil:
+		return ok == *f.Exists
+	case f.NotEquals != nil:
+		return !ok || value != *f.NotEquals
+	case !ok:
+		return false
+	case f.Equals != nil:
+		return value == *f.Equals
+	default:
+		// A regex filter that was never compiled matches nothing
+		return f.re != nil && f.re.MatchString(value)
+	}
+}
+
+// filterObjects returns the objects passing all filters
+func filterObjects(objects []*Object, filters []ObjectFilter) []*Object {
+	if len(filters) == 0 {
+		return objects
+	}
+	var result []*Object
+	for _, obj := range objects {
+		passed := true
+		for i := range filters {
+			if !filters[i].match(*obj) {
+				passed = false
+				break
+			}
+		}
+		if passed {
+			result = append(result, obj)
+		}
+	}
+	return result
+}
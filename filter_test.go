@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"go.yaml.in/yaml/v2"
+)
+
+func stringPtr(s string) *string { return &s }
+
+func boolPtr(b bool) *bool { return &b }
+
+func TestObjectFilterCompile(t *testing.T) {
+	tests := []struct {
+		name          string
+		filter        ObjectFilter
+		expectedError string
+	}{
+		{"equals", ObjectFilter{Property: "architecture", Equals: stringPtr("SSD")}, ""},
+		{"empty equals", ObjectFilter{Property: "architecture", Equals: stringPtr("")}, ""},
+		{"regex", ObjectFilter{Property: "location", Regex: `0\.\d+`}, ""},
+		{"missing property", ObjectFilter{Equals: stringPtr("SSD")}, "property is required"},
+		{"no predicate", ObjectFilter{Property: "architecture"}, "exactly one of"},
+		{"two predicates", ObjectFilter{Property: "architecture", Equals: stringPtr("SSD"), Exists: boolPtr(true)}, "exactly one of"},
+		{"invalid regex", ObjectFilter{Property: "location", Regex: "("}, "invalid regex"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.filter.compile()
+			if tt.expectedError == "" {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+				t.Errorf("Expected error containing %q, got %v", tt.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestObjectFilterMatch(t *testing.T) {
+	ssd := Object{Properties: []Property{
+		{Name: "architecture", Value: "SSD"},
+		{Name: "location", Value: "0.12"},
+	}}
+	// Nested properties are not considered
+	nested := Object{Objects: []Object{ssd}}
+
+	tests := []struct {
+		name     string
+		filter   ObjectFilter
+		obj      Object
+		expected bool
+	}{
+		{"equals", ObjectFilter{Property: "architecture", Equals: stringPtr("SSD")}, ssd, true},
+		{"equals other", ObjectFilter{Property: "architecture", Equals: stringPtr("HDD")}, ssd, false},
+		{"equals missing", ObjectFilter{Property: "architecture", Equals: stringPtr("SSD")}, nested, false},
+		{"not equals", ObjectFilter{Property: "architecture", NotEquals: stringPtr("HDD")}, ssd, true},
+		{"not equals same", ObjectFilter{Property: "architecture", NotEquals: stringPtr("SSD")}, ssd, false},
+		{"not equals missing", ObjectFilter{Property: "architecture", NotEquals: stringPtr("SSD")}, nested, true},
+		{"regex", ObjectFilter{Property: "location", Regex: `0\.\d+`}, ssd, true},
+		{"regex is anchored", ObjectFilter{Property: "location", Regex: `0\.1`}, ssd, false},
+		{"regex missing", ObjectFilter{Property: "location", Regex: `.*`}, nested, false},
+		{"exists", ObjectFilter{Property: "location", Exists: boolPtr(true)}, ssd, true},
+		{"exists missing", ObjectFilter{Property: "location", Exists: boolPtr(true)}, nested, false},
+		{"not exists", ObjectFilter{Property: "location", Exists: boolPtr(false)}, nested, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.filter.compile(); err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.filter.match(tt.obj); got != tt.expected {
+				t.Errorf("match = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestObjectFilterUnmarshal(t *testing.T) {
+	var filters []ObjectFilter
+	if err := yaml.Unmarshal([]byte(`[{property: location, regex: '0\.\d+'}]`), &filters); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	// A copy of the decoded filter keeps its compiled expression
+	filter := filters[0]
+	if !filter.match(Object{Properties: []Property{{Name: "location", Value: "0.12"}}}) {
+		t.Error("Expected the decoded regex filter to match")
+	}
+
+	if err := yaml.Unmarshal([]byte(`[{property: location, regex: '('}]`), &filters); err == nil || !strings.Contains(err.Error(), "invalid regex") {
+		t.Errorf("Expected an invalid regex error, got %v", err)
+	}
+}
+
+func TestObjectFilterUncompiledRegex(t *testing.T) {
+	filter := ObjectFilter{Property: "location", Regex: ".*"}
+	if filter.match(Object{Properties: []Property{{Name: "location", Value: "0.1"}}}) {
+		t.Error("Expected an uncompiled regex filter to match nothing")
+	}
+}
+
+func TestFilterObjects(t *testing.T) {
+	objects := []*Object{
+		{Name: "drive", Properties: []Property{{Name: "architecture", Value: "SSD"}, {Name: "enclosure-id", Value: "0"}}},
+		{Name: "drive", Properties: []Property{{Name: "architecture", Value: "HDD"}, {Name: "enclosure-id", Value: "0"}}},
+		{Name: "drive", Properties: []Property{{Name: "architecture", Value: "SSD"}, {Name: "enclosure-id", Value: "1"}}},
+	}
+	if got := filterObjects(objects, nil); len(got) != 3 {
+		t.Errorf("Expected all objects without filters, got %d", len(got))
+	}
+
+	// All filters must pass
+	filters := []ObjectFilter{
+		{Property: "architecture", Equals: stringPtr("SSD")},
+		{Property: "enclosure-id", NotEquals: stringPtr("1")},
+	}
+	got := filterObjects(objects, filters)
+	if len(got) != 1 || got[0] != objects[0] {
+		t.Errorf("Expected the SSD of enclosure 0, got %+v", got)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// targetState is the readiness report of a single target
+type targetState struct {
+	Target              string    `json:"target"`
+	Ready               bool      `json:"ready"`
+	LastLoginSuccessful bool      `json:"last_login_successful"`
+	LastLogin           time.Time `json:"last_login,omitzero"`
+	LastSuccess         time.Time `json:"last_success,omitzero"`
+	LastError           string    `json:"last_error,omitempty"`
+	SessionAgeSeconds   float64   `json:"session_age_seconds,omitempty"`
+	Controller          string    `json:"controller,omitempty"`
+}
+
+// readiness is the body returned by /-/ready
+type readiness struct {
+	Status  string        `json:"status"`
+	Targets []targetState `json:"targets"`
+}
+
+// healthyHandler reports that the process is alive
+func healthyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = fmt.Fprintf(w, `{"status":"healthy","service":"msa_exporter"}`)
+}
+
+// Status returns the state of every target, sorted by name. A target is
+// ready when its last successful scrape is not older than maxAge. In
+// on-demand mode a target that was never scraped is also ready, since
+// it is only read when Prometheus scrapes the exporter.
+func (m *targetManager) Status(maxAge time.Duration) []targetState {
+	m.mu.Lock()
+	states := make([]targetState, 0, len(m.targets))
+	for _, mt := range m.targets {
+		fresh := !mt.lastSuccess.IsZero() && time.Since(mt.lastSuccess) <= maxAge
+		states = append(states, targetState{
+			Target:      mt.name,
+			Ready:       fresh || (m.mode == modeOnDemand && mt.lastScrape.IsZero()),
+			LastSuccess: mt.lastSuccess,
+			LastError:   mt.lastError,
+		})
+	}
+	m.mu.Unlock()
+
+	for i := range states {
+		session, ok := m.pool.SessionState(states[i].Target)
+		if !ok {
+			continue
+		}
+		states[i].LastLogin = session.LastLogin
+		states[i].Controller = session.Controller
+		states[i].LastLoginSuccessful = !session.LastLogin.IsZero() && session.LoginError == nil
+		if !session.SessionStart.IsZero() {
+			states[i].SessionAgeSeconds = time.Since(session.SessionStart).Seconds()
+		}
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Target < states[j].Target })
+	return states
+}
+
+// readyHandler serves /-/ready and answers 503 when any target has no
+// data newer than maxAge
+func readyHandler(manager *targetManager, maxAge time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body := readiness{Status: "ready", Targets: manager.Status(maxAge)}
+		code := http.StatusOK
+		for _, state := range body.Targets {
+			if !state.Ready {
+				body.Status = "not ready"
+				code = http.StatusServiceUnavailable
+				break
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(body)
+	}
+}
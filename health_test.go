@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// getReadiness calls the readiness handler and decodes its body
+func getReadiness(t *testing.T, handler http.Handler) (int, readiness) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/-/ready", nil))
+	if contentType := rr.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Expected application/json, got %s", contentType)
+	}
+	var body readiness
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode readiness: %v", err)
+	}
+	return rr.Code, body
+}
+
+func TestReadyHandler(t *testing.T) {
+	server := newProbeTestServer(t)
+
+	configFile := writeTestFile(t, "config.yml", `
+targets:
+  - name: good
+    host: `+server.URL[8:]+`
+    login: probeuser
+    password: probepass
+    tls_config:
+      insecure_skip_verify: true
+  - name: bad
+    host: `+server.URL[8:]+`
+    login: probeuser
+    password: wrong
+    tls_config:
+      insecure_skip_verify: true
+`)
+	state, err := NewSafeConfig(&configLoader{configFile: configFile, timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("NewSafeConfig failed: %v", err)
+	}
+	pool := newClientPool()
+	defer pool.Close(t.Context())
+	manager := newTargetManager(t.Context(), state, pool, time.Hour)
+	handler := readyHandler(manager, time.Minute)
+
+	code, body := getReadiness(t, handler)
+	if code != http.StatusOK || len(body.Targets) != 0 {
+		t.Errorf("Expected ready without targets, got %d %+v", code, body)
+	}
+
+	manager.Sync()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		code, body = getReadiness(t, handler)
+		if body.Targets[0].LastError != "" && body.Targets[1].Ready {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Targets were not scraped: %+v", body)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if code != http.StatusServiceUnavailable || body.Status != "not ready" {
+		t.Errorf("Expected 503 not ready, got %d %s", code, body.Status)
+	}
+	bad, good := body.Targets[0], body.Targets[1]
+	if bad.Target != "bad" || bad.Ready || bad.LastLoginSuccessful || bad.LastLogin.IsZero() {
+		t.Errorf("Unexpected state of bad target: %+v", bad)
+	}
+	if good.Target != "good" || !good.LastLoginSuccessful || good.LastSuccess.IsZero() || good.SessionAgeSeconds <= 0 {
+		t.Errorf("Unexpected state of good target: %+v", good)
+	}
+
+	// Data older than the threshold makes the target not ready
+	code, body = getReadiness(t, readyHandler(manager, 0))
+	if code != http.StatusServiceUnavailable || body.Targets[1].Ready {
+		t.Errorf("Expected stale good target to be not ready, got %d %+v", code, body.Targets[1])
+	}
+}
+
+func TestReadyHandlerOnDemand(t *testing.T) {
+	server := newProbeTestServer(t)
+
+	configFile := writeTestFile(t, "config.yml", `
+targets:
+  - name: array1
+    host: `+server.URL[8:]+`
+    login: probeuser
+    password: probepass
+    tls_config:
+      insecure_skip_verify: true
+`)
+	state, err := NewSafeConfig(&configLoader{configFile: configFile, timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("NewSafeConfig failed: %v", err)
+	}
+	manager := newTargetManager(t.Context(), state, newClientPool(), time.Hour)
+	manager.mode = modeOnDemand
+	manager.Sync()
+
+	if code, body := getReadiness(t, readyHandler(manager, time.Minute)); code != http.StatusOK {
+		t.Errorf("Expected target that was never scraped to be ready, got %d %+v", code, body)
+	}
+}
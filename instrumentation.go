@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Self-observability metrics of the exporter, labelled by target
+var (
+	targetUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: prefix + "up",
+		Help: "Whether the last scrape of the MSA array was successful",
+	}, []string{"target"})
+	scrapeDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: prefix + "scrape_duration_seconds",
+		Help: "Duration of the last scrape of the MSA array",
+	}, []string{"target"})
+	scrapeLastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: prefix + "scrape_last_success_timestamp_seconds",
+		Help: "Timestamp of the last successful scrape of the MSA array",
+	}, []string{"target"})
+	apiRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    prefix + "api_request_duration_seconds",
+		Help:    "Duration of MSA API requests by path",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"target", "path"})
+	apiRequestErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prefix + "api_request_errors_total",
+		Help: "Number of MSA API paths that could not be read during a scrape",
+	}, []string{"target", "path", "reason"})
+	loginFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prefix + "login_failures_total",
+		Help: "Number of failed logins to the MSA array",
+	}, []string{"target"})
+	loginCircuitState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: prefix + "login_circuit_state",
+		Help: "State of the login circuit breaker: 0 closed, 1 open, 2 half-open",
+	}, []string{"target"})
+	activeController = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: prefix + "controller_active",
+		Help: "Whether the management address is the controller the data is read from",
+	}, []string{"target", "address"})
+)
+
+func init() {
+	prometheus.MustRegister(targetUp, scrapeDuration, scrapeLastSuccess, apiRequestDuration, apiRequestErrors, loginFailures, loginCircuitState, activeController)
+}
+
+// observeScrape records the outcome of a scrape of the named target
+func observeScrape(name string, start time.Time, err error) {
+	scrapeDuration.WithLabelValues(name).Set(time.Since(start).Seconds())
+	if err != nil {
+		targetUp.WithLabelValues(name).Set(0)
+		return
+	}
+	targetUp.WithLabelValues(name).Set(1)
+	scrapeLastSuccess.WithLabelValues(name).SetToCurrentTime()
+}
+
+// deleteTargetMetrics drops the self-observability series of a removed target
+func deleteTargetMetrics(name string) {
+	labels := prometheus.Labels{"target": name}
+	targetUp.DeletePartialMatch(labels)
+	scrapeDuration.DeletePartialMatch(labels)
+	scrapeLastSuccess.DeletePartialMatch(labels)
+	apiRequestDuration.DeletePartialMatch(labels)
+	apiRequestErrors.DeletePartialMatch(labels)
+	loginFailures.DeletePartialMatch(labels)
+	loginCircuitState.DeletePartialMatch(labels)
+	activeController.DeletePartialMatch(labels)
+}
+
+// errorReason classifies an API error for the reason label
+func errorReason(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, errCircuitOpen):
+		return "circuit_open"
+	case errors.Is(err, errFetchTimeout):
+		return "timeout"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, errLoginFailed):
+		return "login"
+	case errors.Is(err, errSessionExpired):
+		return "session_expired"
+	case errors.Is(err, errErrorResponse):
+		return "error_response"
+	case errors.Is(err, errUnexpectedStatus):
+		return "http_status"
+	case errors.Is(err, errInvalidResponse):
+		return "invalid_response"
+	default:
+		return "network"
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestErrorReason(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{errFetchTimeout, "timeout"},
+		{fmt.Errorf("%w: %w", errLoginFailed, errors.New("failed to authenticate")), "login"},
+		{fmt.Errorf("%w: %w: 401", errSessionExpired, errUnexpectedStatus), "session_expired"},
+		{fmt.Errorf("%w: Command not recognized", errErrorResponse), "error_response"},
+		{fmt.Errorf("%w: 500", errUnexpectedStatus), "http_status"},
+		{fmt.Errorf("%w: unexpected EOF", errInvalidResponse), "invalid_response"},
+		{errors.New("connection refused"), "network"},
+	}
+
+	for _, tt := range tests {
+		if reason := errorReason(tt.err); reason != tt.expected {
+			t.Errorf("errorReason(%v) = %s, expected %s", tt.err, reason, tt.expected)
+		}
+	}
+}
+
+func TestScrapeSelfMetrics(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/login/" + getSHA256("selfuser_selfpass"):
+			_, _ = w.Write([]byte(`<RESPONSE><OBJECT name="status"><PROPERTY name="response">key</PROPERTY></OBJECT></RESPONSE>`))
+		case "/api/show/disks":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/api/login/" + getSHA256("selfuser_wrong"):
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			_, _ = w.Write([]byte(`<RESPONSE></RESPONSE>`))
+		}
+	}))
+	defer server.Close()
+
+	client := newMSAClient(server.URL[8:], "selfuser", "selfpass", 10*time.Second, &tls.Config{InsecureSkipVerify: true})
+	client.target = "self-test"
+	if err := scrapeMSA(t.Context(), client, NewMetricStoreWithRegisterer(prometheus.NewRegistry()), getMetrics()); err != nil {
+		t.Fatalf("scrapeMSA failed: %v", err)
+	}
+	if v := testutil.ToFloat64(apiRequestErrors.WithLabelValues("self-test", "disks", "http_status")); v != 1 {
+		t.Errorf("Expected 1 disks error, got %v", v)
+	}
+	if n := testutil.CollectAndCount(apiRequestDuration, prefix+"api_request_duration_seconds"); n == 0 {
+		t.Error("Expected API request durations to be observed")
+	}
+
+	client = newMSAClient(server.URL[8:], "selfuser", "wrong", 10*time.Second, &tls.Config{InsecureSkipVerify: true})
+	client.target = "self-test"
+	if err := scrapeMSA(t.Context(), client, NewMetricStoreWithRegisterer(prometheus.NewRegistry()), getMetrics()); err == nil {
+		t.Fatal("Expected scrape with wrong password to fail")
+	}
+	if v := testutil.ToFloat64(loginFailures.WithLabelValues("self-test")); v < 1 {
+		t.Errorf("Expected login failures to be counted, got %v", v)
+	}
+	if v := testutil.ToFloat64(apiRequestErrors.WithLabelValues("self-test", "version", "login")); v != 1 {
+		t.Errorf("Expected 1 version login error, got %v", v)
+	}
+
+	deleteTargetMetrics("self-test")
+	if n := apiRequestErrors.DeletePartialMatch(prometheus.Labels{"target": "self-test"}); n != 0 {
+		t.Errorf("Expected series of removed target to be deleted, %d left", n)
+	}
+}
+
+func TestObserveScrape(t *testing.T) {
+	observeScrape("observe-test", time.Now(), nil)
+	if v := testutil.ToFloat64(targetUp.WithLabelValues("observe-test")); v != 1 {
+		t.Errorf("Expected up 1, got %v", v)
+	}
+	if v := testutil.ToFloat64(scrapeLastSuccess.WithLabelValues("observe-test")); v == 0 {
+		t.Error("Expected last success timestamp to be set")
+	}
+
+	lastSuccess := testutil.ToFloat64(scrapeLastSuccess.WithLabelValues("observe-test"))
+	observeScrape("observe-test", time.Now(), errors.New("failed"))
+	if v := testutil.ToFloat64(targetUp.WithLabelValues("observe-test")); v != 0 {
+		t.Errorf("Expected up 0, got %v", v)
+	}
+	if v := testutil.ToFloat64(scrapeLastSuccess.WithLabelValues("observe-test")); v != lastSuccess {
+		t.Error("Expected last success timestamp to be kept on failure")
+	}
+	deleteTargetMetrics("observe-test")
+}
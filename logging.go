@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// levelTrace is below debug and dumps the structure of API responses
+const levelTrace = slog.LevelDebug - 4
+
+// logLevels maps --log.level values to slog levels
+var logLevels = map[string]slog.Level{
+	"trace": levelTrace,
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// newLogger creates a logger writing lines of the given format, logfmt or
+// json, at or above the given level
+func newLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	lvl, ok := logLevels[strings.ToLower(level)]
+	if !ok {
+		return nil, fmt.Errorf("unknown log level %q, expected trace, debug, info, warn or error", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl, ReplaceAttr: replaceLevel}
+	switch format {
+	case "logfmt":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q, expected logfmt or json", format)
+	}
+}
+
+// replaceLevel names the trace level, which slog would print as DEBUG-4
+func replaceLevel(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.LevelKey && len(groups) == 0 {
+		if level, ok := a.Value.Any().(slog.Level); ok && level == levelTrace {
+			a.Value = slog.StringValue("TRACE")
+		}
+	}
+	return a
+}
+
+// fatal logs an error and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// traceFilter selects what is logged at trace level: the responses of the
+// paths named with --log.trace-path and the values of the metrics named
+// with --log.trace-metric. Without any names everything is traced.
+type traceFilter struct {
+	paths   map[string]bool
+	metrics map[string]bool
+}
+
+// tracing is the trace filter set from the command line
+var tracing traceFilter
+
+// newTraceFilter creates a filter for the given paths and metrics
+func newTraceFilter(paths, metrics []string) traceFilter {
+	f := traceFilter{paths: make(map[string]bool), metrics: make(map[string]bool)}
+	for _, path := range paths {
+		f.paths[path] = true
+	}
+	for _, metric := range metrics {
+		f.metrics[strings.TrimPrefix(metric, prefix)] = true
+	}
+	return f
+}
+
+// all reports whether no paths or metrics were named
+func (f traceFilter) all() bool {
+	return len(f.paths) == 0 && len(f.metrics) == 0
+}
+
+// path reports whether the response of an API path is traced
+func (f traceFilter) path(path string) bool {
+	return f.all() || f.paths[path]
+}
+
+// metric reports whether the values of a metric are traced
+func (f traceFilter) metric(name string) bool {
+	return f.all() || f.metrics[name]
+}
+
+// traceObjects logs the structure of response objects at trace level
+func traceObjects(ctx context.Context, logger *slog.Logger, objects []Object, depth int) {
+	for _, obj := range objects {
+		logger.Log(ctx, levelTrace, "Response object",
+			"object", obj.Name, "depth", depth, "properties", len(obj.Properties), "nested", len(obj.Objects))
+		traceObjects(ctx, logger, obj.Objects, depth+1)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "debug", "logfmt")
+	if err != nil {
+		t.Fatalf("newLogger failed: %v", err)
+	}
+	logger.Debug("Retrying request", "target", "msa1", "path", "disks")
+	logger.Log(t.Context(), levelTrace, "hidden")
+	if line := buf.String(); !strings.Contains(line, "level=DEBUG") || !strings.Contains(line, "target=msa1 path=disks") {
+		t.Errorf("Unexpected logfmt line: %s", line)
+	}
+	if strings.Contains(buf.String(), "hidden") {
+		t.Error("Trace message logged at debug level")
+	}
+
+	buf.Reset()
+	logger, err = newLogger(&buf, "TRACE", "json")
+	if err != nil {
+		t.Fatalf("newLogger failed: %v", err)
+	}
+	logger.Log(t.Context(), levelTrace, "Metric value", "metric", "disk_temperature")
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("Expected a JSON line, got %q: %v", buf.String(), err)
+	}
+	if line["level"] != "TRACE" || line["metric"] != "disk_temperature" {
+		t.Errorf("Unexpected JSON line: %v", line)
+	}
+
+	for _, tc := range [][2]string{{"verbose", "logfmt"}, {"info", "text"}} {
+		if _, err := newLogger(&buf, tc[0], tc[1]); err == nil {
+			t.Errorf("Expected error for level %q and format %q", tc[0], tc[1])
+		}
+	}
+}
+
+func TestTraceFilter(t *testing.T) {
+	all := newTraceFilter(nil, nil)
+	if !all.path("disks") || !all.metric("disk_temperature") {
+		t.Error("Expected everything to be traced without names")
+	}
+
+	f := newTraceFilter([]string{"pool-statistics"}, []string{"msa_tier_reads"})
+	if !f.path("pool-statistics") || f.path("disks") {
+		t.Error("Expected only pool-statistics responses to be traced")
+	}
+	if !f.metric("tier_reads") || f.metric("disk_temperature") {
+		t.Error("Expected only tier_reads values to be traced")
+	}
+}
+
+func TestTraceObjects(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "trace", "logfmt")
+	if err != nil {
+		t.Fatalf("newLogger failed: %v", err)
+	}
+	objects := []Object{{
+		Name:       "pool-statistics",
+		Properties: []Property{{Name: "pool", Value: "A"}},
+		Objects:    []Object{{Name: "tier-statistics"}},
+	}}
+	traceObjects(t.Context(), logger.With("path", "pool-statistics"), objects, 0)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %d: %s", len(lines), buf.String())
+	}
+	if !strings.Contains(lines[1], "object=tier-statistics depth=1") || !strings.Contains(lines[1], "path=pool-statistics") {
+		t.Errorf("Unexpected nested object line: %s", lines[1])
+	}
+
+	// Nothing is formatted unless trace is enabled
+	buf.Reset()
+	traceObjects(t.Context(), slog.New(slog.NewTextHandler(&buf, nil)), objects, 0)
+	if buf.Len() != 0 {
+		t.Errorf("Expected no output at info level, got %s", buf.String())
+	}
+}
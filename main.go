@@ -1,22 +1,33 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"crypto/tls"
 	"encoding/xml"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"maps"
 	"math"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/exporter-toolkit/web"
 )
 
 const (
@@ -30,53 +41,89 @@ type Property struct {
 }
 
 type Object struct {
-	Name       string     `xml:"name,attr"`
+	Name     string `xml:"name,attr"`
+	Basetype string `xml:"basetype,attr"`
+	OID      string `xml:"oid,attr"`
+	// Attrs holds the remaining attributes, such as format
+	Attrs      []xml.Attr `xml:",any,attr"`
 	Properties []Property `xml:"PROPERTY"`
 	Objects    []Object   `xml:"OBJECT"`
 }
 
+// Property returns the value of a property of the object itself,
+// without searching nested objects
+func (o Object) Property(name string) (string, bool) {
+	for _, prop := range o.Properties {
+		if prop.Name == name {
+			return prop.Value, true
+		}
+	}
+	return "", false
+}
+
 type Response struct {
 	Objects []Object `xml:"OBJECT"`
 }
 
 // MetricSource defines how to collect a metric
 type MetricSource struct {
-	Path              string
-	ObjectSelector    string
-	PropertySelector  string
-	PropertiesAsLabel map[string]string
-	Labels            map[string]interface{}
+	Path string `yaml:"path"`
+	// ObjectSelector and ObjectBasetype select objects by their name and
+	// basetype attributes; either or both may be set
+	ObjectSelector string `yaml:"object_selector"`
+	ObjectBasetype string `yaml:"object_basetype"`
+	// Filters keep only the selected objects passing all of them
+	Filters           []ObjectFilter    `yaml:"filters"`
+	PropertySelector  string            `yaml:"property_selector"`
+	PropertiesAsLabel map[string]string `yaml:"properties_as_label"`
+	// ParentLabels take labels from ancestors of the selected objects
+	ParentLabels []ParentLabels         `yaml:"parent_labels"`
+	Labels       map[string]interface{} `yaml:"labels"`
+}
+
+// ParentLabels maps properties of the nearest ancestor matching the
+// selectors to labels, such as the pool of tier statistics
+type ParentLabels struct {
+	ObjectSelector    string            `yaml:"object_selector"`
+	ObjectBasetype    string            `yaml:"object_basetype"`
+	PropertiesAsLabel map[string]string `yaml:"properties_as_label"`
 }
 
 // MetricDefinition defines a metric to collect
 type MetricDefinition struct {
-	Description string
-	Sources     []MetricSource
+	Description string         `yaml:"description"`
+	Sources     []MetricSource `yaml:"sources"`
+	// LabelNames declares the label schema; if empty it is derived from the sources
+	LabelNames []string `yaml:"label_names"`
+	// MissingLabelValue is used for labels whose property is missing from an object
+	MissingLabelValue string `yaml:"missing_label_value"`
 }
 
-// MetricStore manages Prometheus metrics
+// MetricStore manages Prometheus metrics.
+// It remembers the series set during a scrape so that series which
+// disappeared from the array (deleted volumes, replaced disks) can be removed.
 type MetricStore struct {
-	mu      sync.Mutex
-	metrics map[string]*prometheus.GaugeVec
+	mu         sync.Mutex
+	metrics    map[string]*prometheus.GaugeVec
+	registerer prometheus.Registerer
+	schemas    map[string][]string
+	series     map[string]map[string]prometheus.Labels
+	touched    map[string]map[string]bool
 }
 
-// NewMetricStore creates a new MetricStore
-func NewMetricStore() *MetricStore {
+// NewMetricStoreWithRegisterer creates a new MetricStore that registers its metrics with reg
+func NewMetricStoreWithRegisterer(reg prometheus.Registerer) *MetricStore {
 	return &MetricStore{
-		metrics: make(map[string]*prometheus.GaugeVec),
+		metrics:    make(map[string]*prometheus.GaugeVec),
+		registerer: reg,
+		schemas:    make(map[string][]string),
+		series:     make(map[string]map[string]prometheus.Labels),
+		touched:    make(map[string]map[string]bool),
 	}
 }
 
-// GetOrCreate gets or creates a Prometheus gauge metric
-func (ms *MetricStore) GetOrCreate(name, description string, labelNames []string) *prometheus.GaugeVec {
-	ms.mu.Lock()
-	defer ms.mu.Unlock()
-
-	key := name
-	if metric, exists := ms.metrics[key]; exists {
-		return metric
-	}
-
+// createLocked creates and registers a gauge with a fixed label schema
+func (ms *MetricStore) createLocked(name, description string, labelNames []string) (*prometheus.GaugeVec, error) {
 	metric := prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: name,
@@ -84,130 +131,613 @@ func (ms *MetricStore) GetOrCreate(name, description string, labelNames []string
 		},
 		labelNames,
 	)
-	prometheus.MustRegister(metric)
-	ms.metrics[key] = metric
+	if err := ms.registerer.Register(metric); err != nil {
+		return nil, fmt.Errorf("failed to register %s: %w", name, err)
+	}
+	ms.metrics[name] = metric
+	ms.schemas[name] = slices.Sorted(slices.Values(labelNames))
+	return metric, nil
+}
+
+// Set sets the value of a series and marks it as touched in the current scrape.
+// The labels must match the label schema the metric was first created with.
+func (ms *MetricStore) Set(name, description string, labels map[string]string, value float64) error {
+	labelNames := slices.Sorted(maps.Keys(labels))
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	metric, exists := ms.metrics[name]
+	if !exists {
+		var err error
+		if metric, err = ms.createLocked(name, description, labelNames); err != nil {
+			return err
+		}
+	} else if !slices.Equal(ms.schemas[name], labelNames) {
+		return fmt.Errorf("labels %v of %s do not match its schema %v", labelNames, name, ms.schemas[name])
+	}
+
+	gauge, err := metric.GetMetricWith(labels)
+	if err != nil {
+		return fmt.Errorf("failed to set %s: %w", name, err)
+	}
+	gauge.Set(value)
+
+	key := seriesKey(labels)
+	if ms.series[name] == nil {
+		ms.series[name] = make(map[string]prometheus.Labels)
+		ms.touched[name] = make(map[string]bool)
+	}
+	if _, ok := ms.series[name][key]; !ok {
+		ms.series[name][key] = labels
+	}
+	ms.touched[name][key] = true
+	return nil
+}
+
+// BeginScrape starts a new scrape; series not set again before Sweep are removed
+func (ms *MetricStore) BeginScrape() {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for name := range ms.touched {
+		ms.touched[name] = make(map[string]bool)
+	}
+}
 
-	return metric
+// Retain marks every series of a metric as set in the current scrape, so
+// Sweep keeps them while the path they come from cannot be fetched
+func (ms *MetricStore) Retain(name string) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for key := range ms.series[name] {
+		ms.touched[name][key] = true
+	}
 }
 
-// MSAClient represents a client for the MSA API
+// Sweep removes the series that were not set since BeginScrape and returns their number
+func (ms *MetricStore) Sweep() int {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	removed := 0
+	for name, series := range ms.series {
+		for key, labels := range series {
+			if ms.touched[name][key] {
+				continue
+			}
+			ms.metrics[name].Delete(labels)
+			delete(series, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Metrics returns the current samples of all metrics in the store
+func (ms *MetricStore) Metrics() []prometheus.Metric {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		for _, metric := range ms.metrics {
+			metric.Collect(ch)
+		}
+		close(ch)
+	}()
+
+	var result []prometheus.Metric
+	for metric := range ch {
+		result = append(result, metric)
+	}
+	return result
+}
+
+// seriesKey returns a stable identifier for a label set
+func seriesKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(labels[name])
+		b.WriteByte(0xff)
+	}
+	return b.String()
+}
+
+var (
+	// errSessionExpired is returned when the array rejects the session key
+	errSessionExpired = errors.New("session expired")
+	// errErrorResponse matches every *APIError
+	errErrorResponse = errors.New("error response")
+	// errUnexpectedStatus matches every *statusError
+	errUnexpectedStatus = errors.New("request failed with status")
+	// errLoginFailed is returned when a request cannot get a session
+	errLoginFailed = errors.New("login failed")
+	// errConnectionFailed is returned when a controller could not be reached
+	errConnectionFailed = errors.New("connection failed")
+)
+
+// statusError is returned for HTTP status codes other than 200
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("request failed with status: %d", e.code)
+}
+
+// Is makes errors.Is(err, errUnexpectedStatus) match any status error
+func (e *statusError) Is(target error) bool {
+	return target == errUnexpectedStatus
+}
+
+// MSAClient represents a client for the MSA API.
+// A client keeps its session key and keep-alive connections between
+// requests and logs in again only when the session has expired.
 type MSAClient struct {
-	host       string
+	// target is the target name used as label of the client's metrics
+	target string
+	// hosts are the management addresses of the controllers in order of
+	// preference, host is the active one and guarded by mu
+	hosts []string
+	host  string
+	// failbackAfter is how long the client stays on a partner controller
+	// before trying the preferred one again
+	failbackAfter time.Duration
+	login         string
+	// password is read from passwordFile, if set, on the first login and
+	// again whenever a login fails
+	password     string
+	passwordFile string
+	httpClient   *http.Client
+	timeout      time.Duration
+	// concurrency is the number of API paths fetched in parallel during a scrape
+	concurrency int
+
+	// limiter caps the sessions open on the array, nil means unlimited
+	limiter *sessionLimiter
+	// maxRetries and retryBackoff control retries of failed show requests
+	maxRetries   int
+	retryBackoff time.Duration
+	// breaker rejects logins after repeated failures, nil disables it
+	breaker *circuitBreaker
+	// adhoc clients serve /probe targets that are not configured. They are
+	// kept out of the self-observability metrics, whose cardinality would
+	// otherwise grow with every address probed.
+	adhoc bool
+
+	mu         sync.Mutex
 	sessionKey string
-	login      string
-	httpClient *http.Client
-	timeout    time.Duration
+	// active is the index of host in hosts, failedOverAt when it was chosen
+	active       int
+	failedOverAt time.Time
+	// state is the outcome of the last login and the age of the session
+	state sessionState
+}
+
+// msaSession is a session key together with the controller that issued it
+type msaSession struct {
+	host string
+	key  string
+}
+
+// sessionState describes the session of a client for readiness reporting
+type sessionState struct {
+	LastLogin    time.Time
+	LoginError   error
+	SessionStart time.Time
+	Controller   string
 }
 
-// NewMSAClient creates a new MSA API client
-func NewMSAClient(host, login, password string, timeout time.Duration) (*MSAClient, error) {
-	// Create HTTP client with insecure TLS
+// newMSAClient creates a client for a normalized address that logs in on
+// its first request
+func newMSAClient(host, login, password string, timeout time.Duration, tlsConfig *tls.Config) *MSAClient {
 	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		TLSClientConfig: tlsConfig,
 	}
-	httpClient := &http.Client{
-		Transport: tr,
-		Timeout:   timeout,
+	return &MSAClient{
+		target:   host,
+		hosts:    []string{host},
+		host:     host,
+		login:    login,
+		password: password,
+		httpClient: &http.Client{
+			Transport: tr,
+			Timeout:   timeout,
+		},
+		timeout:     timeout,
+		concurrency: defaultConcurrency,
 	}
+}
+
+// Login authenticates against the array and stores the new session key.
+// An existing session is logged out first.
+func (c *MSAClient) Login(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.logoutLocked(ctx)
+	return c.loginLocked(ctx)
+}
 
-	client := &MSAClient{
-		host:       host,
-		login:      login,
-		httpClient: httpClient,
-		timeout:    timeout,
+// loginLocked opens a new session. The client must not hold a session.
+func (c *MSAClient) loginLocked(ctx context.Context) error {
+	if err := c.breaker.allow(); err != nil {
+		return err
+	}
+	if c.limiter != nil {
+		if err := c.limiter.acquire(); err != nil {
+			return err
+		}
+	}
+	var err error
+	if c.password == "" {
+		_, err = c.reloadPasswordLocked()
+	}
+	if err == nil {
+		err = c.loginHostsLocked(ctx)
 	}
+	if err != nil && ctx.Err() == nil {
+		// A rotated password file takes effect without a restart
+		if changed, readErr := c.reloadPasswordLocked(); readErr != nil {
+			c.logger().Warn("Failed to re-read password", "err", readErr)
+		} else if changed {
+			c.logger().Info("Password file has changed, logging in again")
+			err = c.loginHostsLocked(ctx)
+		}
+	}
+	if ctx.Err() == nil {
+		// A cancelled login says nothing about the array
+		c.breaker.record(err)
+	}
+	c.state.LastLogin = time.Now()
+	c.state.LoginError = err
+	if err != nil {
+		if !c.adhoc {
+			loginFailures.WithLabelValues(c.target).Inc()
+		}
+		if c.limiter != nil {
+			c.limiter.release()
+		}
+		return err
+	}
+	c.state.SessionStart = c.state.LastLogin
+	c.state.Controller = c.host
+	return nil
+}
+
+// loginHostsLocked logs in to the active controller first, then fails over
+// to its partners
+func (c *MSAClient) loginHostsLocked(ctx context.Context) error {
+	var err error
+	for i := range c.hosts {
+		next := (c.active + i) % len(c.hosts)
+		if err = c.authenticateLocked(ctx, c.hosts[next]); err == nil {
+			c.useHostLocked(next)
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		if len(c.hosts) > 1 {
+			c.logger().Warn("Login to controller failed", "controller", c.hosts[next], "err", err)
+		}
+	}
+	return err
+}
 
-	// Authenticate
-	creds := fmt.Sprintf("%s_%s", login, password)
+// reloadPasswordLocked re-reads the password file, if any, and reports
+// whether the password has changed
+func (c *MSAClient) reloadPasswordLocked() (bool, error) {
+	if c.passwordFile == "" {
+		return false, nil
+	}
+	password, err := readPasswordFile(c.passwordFile)
+	if err != nil || password == c.password {
+		return false, err
+	}
+	c.password = password
+	return true, nil
+}
+
+// useHostLocked makes hosts[i] the active controller
+func (c *MSAClient) useHostLocked(i int) {
+	if i == c.active && c.host == c.hosts[i] {
+		return
+	}
+	c.logger().Info("Switching controller", "from", c.host, "to", c.hosts[i])
+	c.active = i
+	c.host = c.hosts[i]
+	c.failedOverAt = time.Now()
+	c.reportControllerLocked()
+}
+
+// reportControllerLocked updates msa_controller_active for all controllers
+func (c *MSAClient) reportControllerLocked() {
+	if c.adhoc {
+		return
+	}
+	for i, host := range c.hosts {
+		value := 0.0
+		if i == c.active {
+			value = 1
+		}
+		activeController.WithLabelValues(c.target, host).Set(value)
+	}
+}
+
+// failbackDueLocked reports whether the client has been on a partner
+// controller long enough to try the preferred one again
+func (c *MSAClient) failbackDueLocked() bool {
+	return c.active != 0 && c.failbackAfter > 0 && time.Since(c.failedOverAt) >= c.failbackAfter
+}
+
+// authenticateLocked logs in to the given controller and stores the session key
+func (c *MSAClient) authenticateLocked(ctx context.Context, host string) error {
+	creds := fmt.Sprintf("%s_%s", c.login, c.password)
 	hash := sha256.Sum256([]byte(creds))
 	hashStr := fmt.Sprintf("%x", hash)
 
-	url := fmt.Sprintf("https://%s/api/login/%s", host, hashStr)
-	resp, err := httpClient.Get(url)
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL(host, "login", hashStr), nil)
+	if err != nil {
+		return fmt.Errorf("failed to authenticate: %w", redactLoginURL(err, host))
+	}
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to authenticate: %w", err)
+		return fmt.Errorf("failed to authenticate: %w", redactLoginURL(err, host))
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("authentication failed with status: %d", resp.StatusCode)
+		return fmt.Errorf("authentication failed with status: %d", resp.StatusCode)
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read auth response: %w", err)
+		return fmt.Errorf("failed to read auth response: %w", err)
 	}
 
 	var response Response
 	if err := xml.Unmarshal(body, &response); err != nil {
-		return nil, fmt.Errorf("failed to parse auth response: %w", err)
+		return fmt.Errorf("failed to parse auth response: %w", err)
+	}
+	if apiErr := statusAPIError(response.Objects); apiErr != nil {
+		return fmt.Errorf("authentication failed: %w", apiErr)
 	}
 
 	// Extract session key
+	sessionKey := ""
 	for _, obj := range response.Objects {
 		for _, prop := range obj.Properties {
 			if prop.Name == "response" {
-				client.sessionKey = prop.Value
+				sessionKey = prop.Value
 				break
 			}
 		}
 	}
 
-	if client.sessionKey == "" {
-		return nil, fmt.Errorf("session key not found in response")
+	if sessionKey == "" {
+		return fmt.Errorf("session key not found in response")
 	}
 
-	return client, nil
+	c.sessionKey = sessionKey
+	return nil
 }
 
-// Get performs a GET request to the MSA API
-func (c *MSAClient) Get(path string) ([]byte, error) {
-	url := fmt.Sprintf("https://%s/api/show/%s", c.host, path)
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
+// redactLoginURL removes the login hash from the URL in a request error,
+// since the hash is as good as the password for logging in
+func redactLoginURL(err error, host string) error {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return err
 	}
+	return &url.Error{Op: urlErr.Op, URL: apiURL(host, "login", "REDACTED"), Err: urlErr.Err}
+}
 
-	req.Header.Set("sessionKey", c.sessionKey)
-	req.AddCookie(&http.Cookie{Name: "wbisessionkey", Value: c.sessionKey})
-	req.AddCookie(&http.Cookie{Name: "wbiusername", Value: c.login})
+// session returns the current session, logging in if there is none.
+// After failbackAfter on a partner controller the preferred one is tried again.
+func (c *MSAClient) session(ctx context.Context) (msaSession, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failbackDueLocked() {
+		c.logoutLocked(ctx)
+		c.useHostLocked(0)
+	}
+	if c.sessionKey == "" {
+		if err := c.loginLocked(ctx); err != nil {
+			return msaSession{}, err
+		}
+	}
+	return msaSession{host: c.host, key: c.sessionKey}, nil
+}
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, err
+// relogin replaces an expired session. Concurrent callers holding
+// the same stale session share a single login.
+func (c *MSAClient) relogin(ctx context.Context, stale msaSession) (msaSession, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if current := (msaSession{host: c.host, key: c.sessionKey}); current.key != "" && current != stale {
+		return current, nil
 	}
-	defer resp.Body.Close()
+	c.logoutLocked(ctx)
+	if err := c.loginLocked(ctx); err != nil {
+		return msaSession{}, err
+	}
+	return msaSession{host: c.host, key: c.sessionKey}, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("request failed with status: %d", resp.StatusCode)
+// failover abandons a session on a controller that cannot be reached and
+// logs in to its partner. Concurrent callers share a single failover.
+func (c *MSAClient) failover(ctx context.Context, stale msaSession) (msaSession, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if current := (msaSession{host: c.host, key: c.sessionKey}); current.key != "" && current != stale {
+		return current, nil
+	}
+	// Logging out would only wait for the unreachable controller
+	c.dropSessionLocked()
+	c.useHostLocked((c.active + 1) % len(c.hosts))
+	if err := c.loginLocked(ctx); err != nil {
+		return msaSession{}, err
 	}
+	return msaSession{host: c.host, key: c.sessionKey}, nil
+}
 
-	return io.ReadAll(resp.Body)
+// Logout ends the current session on the array
+func (c *MSAClient) Logout(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.logoutLocked(ctx)
 }
 
-// Helper function to find objects by name
-func findObjects(objects []Object, name string) []Object {
-	var result []Object
-	for _, obj := range objects {
-		if obj.Name == name {
-			result = append(result, obj)
+// logoutLocked calls /api/exit for the current session. Errors are ignored
+// since the session is abandoned either way and will time out on the array.
+func (c *MSAClient) logoutLocked(ctx context.Context) {
+	if c.sessionKey == "" {
+		return
+	}
+	url := apiURL(c.host, "exit")
+	if req, err := http.NewRequestWithContext(ctx, "GET", url, nil); err == nil {
+		c.setSessionHeaders(req, c.sessionKey)
+		if resp, err := c.httpClient.Do(req); err == nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
 		}
-		// Recursively search nested objects
-		if len(obj.Objects) > 0 {
-			result = append(result, findObjects(obj.Objects, name)...)
+	}
+	c.dropSessionLocked()
+}
+
+// dropSessionLocked forgets the current session without calling /api/exit
+func (c *MSAClient) dropSessionLocked() {
+	if c.sessionKey == "" {
+		return
+	}
+	c.sessionKey = ""
+	c.state.SessionStart = time.Time{}
+	if c.limiter != nil {
+		c.limiter.release()
+	}
+}
+
+// SessionState returns the result of the last login and when the session was opened
+func (c *MSAClient) SessionState() sessionState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// logger returns the default logger with the client's target
+func (c *MSAClient) logger() *slog.Logger {
+	return slog.With("target", c.target)
+}
+
+// setSessionHeaders attaches the session key to a request
+func (c *MSAClient) setSessionHeaders(req *http.Request, sessionKey string) {
+	req.Header.Set("sessionKey", sessionKey)
+	req.AddCookie(&http.Cookie{Name: "wbisessionkey", Value: sessionKey})
+	req.AddCookie(&http.Cookie{Name: "wbiusername", Value: c.login})
+}
+
+// responseReader parses a response body into a document
+type responseReader func(body io.Reader) (*document, error)
+
+// GetDocument performs a GET request to the MSA API and parses the
+// response while it is read
+func (c *MSAClient) GetDocument(ctx context.Context, path string) (*document, error) {
+	return c.request(ctx, path, decodeDocument)
+}
+
+// request performs a GET request and reads the response with read.
+// Transient failures are retried with jittered exponential backoff.
+func (c *MSAClient) request(ctx context.Context, path string, read responseReader) (*document, error) {
+	for attempt := 0; ; attempt++ {
+		doc, err := c.getWithSession(ctx, path, read)
+		if err == nil || attempt >= c.maxRetries || !retryable(err) {
+			return doc, err
+		}
+		delay := retryDelay(c.retryBackoff, attempt)
+		c.logger().Debug("Retrying request", "path", path, "delay", delay, "err", err)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(delay):
 		}
 	}
-	return result
 }
 
-// Helper function to print XML structure for debugging
-func printXMLStructure(objects []Object, prefix string) {
-	for _, obj := range objects {
-		log.Printf("%sObject: %s (props: %d, nested: %d)", prefix, obj.Name, len(obj.Properties), len(obj.Objects))
-		if len(obj.Objects) > 0 {
-			printXMLStructure(obj.Objects, prefix+"  ")
+// getWithSession performs a GET request, logging in again once if the
+// session has expired or failing over if the controller is unreachable
+func (c *MSAClient) getWithSession(ctx context.Context, path string, read responseReader) (*document, error) {
+	sess, err := c.session(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errLoginFailed, err)
+	}
+
+	doc, err := c.get(ctx, path, sess, read)
+	switch {
+	case errors.Is(err, errConnectionFailed) && len(c.hosts) > 1 && ctx.Err() == nil:
+		sess, err = c.failover(ctx, sess)
+	case errors.Is(err, errSessionExpired):
+		sess, err = c.relogin(ctx, sess)
+	default:
+		return doc, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errLoginFailed, err)
+	}
+	return c.get(ctx, path, sess, read)
+}
+
+// get performs a single GET request within the given session. A response
+// reporting an error in its status object is returned as *APIError.
+func (c *MSAClient) get(ctx context.Context, path string, sess msaSession, read responseReader) (*document, error) {
+	url := apiURL(sess.host, "show", path)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c.setSessionHeaders(req, sess.key)
+
+	start := time.Now()
+	defer func() {
+		if !c.adhoc {
+			apiRequestDuration.WithLabelValues(c.target, path).Observe(time.Since(start).Seconds())
+		}
+	}()
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errConnectionFailed, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return nil, fmt.Errorf("%w: %w", errSessionExpired, &statusError{code: resp.StatusCode})
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{code: resp.StatusCode}
+	}
+
+	doc, err := read(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if doc != nil {
+		if apiErr := statusAPIError(doc.Objects); apiErr != nil {
+			if apiErr.InvalidSession() {
+				return nil, fmt.Errorf("%w: %w", errSessionExpired, apiErr)
+			}
+			return nil, apiErr
 		}
 	}
+	return doc, nil
 }
 
 // Helper function to find property by name (searches recursively in nested objects)
@@ -238,113 +768,133 @@ func extractLabels(obj Object, mapping map[string]string) map[string]string {
 	return labels
 }
 
+// countErrors counts API paths that could not be read because of err
+func (c *MSAClient) countErrors(paths []string, err error) {
+	if c.adhoc {
+		return
+	}
+	for _, path := range paths {
+		apiRequestErrors.WithLabelValues(c.target, path, errorReason(err)).Inc()
+	}
+}
+
 // scrapeMSA collects metrics from MSA storage
-func scrapeMSA(client *MSAClient, metricStore *MetricStore) error {
-	pathCache := make(map[string][]byte)
+func scrapeMSA(ctx context.Context, client *MSAClient, metricStore *MetricStore, metrics map[string]MetricDefinition) error {
+	// The login and all requests share the client's timeout
+	ctx, cancel := context.WithTimeout(ctx, client.timeout)
+	defer cancel()
+
+	paths := append([]string{"version"}, metricPaths(metrics)...)
+
+	// Log in before fetching in parallel, so a rejected login is tried
+	// once per scrape rather than once per path
+	if _, err := client.session(ctx); err != nil {
+		err = fmt.Errorf("%w: %w", errLoginFailed, err)
+		client.countErrors(uniquePaths(paths), err)
+		return err
+	}
 
-	// Collect firmware version
-	versionData, err := client.Get("version")
-	if err != nil {
-		return fmt.Errorf("failed to get version: %w", err)
+	// Fetch every distinct path up front, bounded by the client's concurrency and timeout
+	pathCache := fetchPaths(ctx, client, paths, client.concurrency, client.timeout)
+	for path, result := range pathCache {
+		if result.err != nil {
+			client.countErrors([]string{path}, result.err)
+		}
 	}
+	logger := client.logger()
+	metricStore.BeginScrape()
 
-	var versionResp Response
-	if err := xml.Unmarshal(versionData, &versionResp); err != nil {
-		return fmt.Errorf("failed to parse version: %w", err)
+	// Collect firmware version
+	version := pathCache["version"]
+	if version.err != nil {
+		return fmt.Errorf("failed to get version: %w", version.err)
 	}
 
 	// Process firmware versions
 	for _, controller := range []string{"controller-a-versions", "controller-b-versions"} {
-		for _, obj := range findObjects(versionResp.Objects, controller) {
+		for _, obj := range version.doc.named(controller) {
 			labels := map[string]string{"controller": controller}
-			for _, version := range []string{"bundle-version", "bundle-base-version", "sc-fw", "mc-fw", "pld-rev"} {
-				if val, ok := findProperty(obj, version); ok {
-					labelKey := ""
-					switch version {
-					case "bundle-version":
-						labelKey = "bundle_version"
-					case "bundle-base-version":
-						labelKey = "bundle_base_version"
-					case "sc-fw":
-						labelKey = "sc_fw"
-					case "mc-fw":
-						labelKey = "mc_fw"
-					case "pld-rev":
-						labelKey = "pld_rev"
-					}
-					labels[labelKey] = val
+			for property, label := range versionLabels {
+				labels[label] = defaultMissingLabelValue
+				if val, ok := findProperty(*obj, property); ok {
+					labels[label] = val
 				}
 			}
-			labelNames := make([]string, 0, len(labels))
-			for k := range labels {
-				labelNames = append(labelNames, k)
+			if err := metricStore.Set(prefix+"version", "Firmware Versions", labels, 1); err != nil {
+				logger.Warn("Failed to set metric", "path", "version", "metric", "version", "err", err)
 			}
-			metric := metricStore.GetOrCreate(prefix+"version", "Firmware Versions", labelNames)
-			metric.With(labels).Set(1)
 		}
 	}
 
-	// Process all metrics
-	for name, metricDef := range getMetrics() {
-		metricName := prefix + name
-		for _, source := range metricDef.Sources {
-			// Get or cache the path data
-			if _, ok := pathCache[source.Path]; !ok {
-				data, err := client.Get(source.Path)
-				if err != nil {
-					log.Printf("Failed to get %s: %v", source.Path, err)
-					continue
-				}
-				pathCache[source.Path] = data
+	for _, path := range paths {
+		if err := pathCache[path].err; err != nil && path != "version" {
+			logger.Warn("Failed to get path", "path", path, "err", err)
+		}
+	}
+
+	// Dump the structure of the responses selected for tracing
+	if logger.Enabled(ctx, levelTrace) {
+		for _, path := range paths {
+			if fetched := pathCache[path]; fetched.err == nil && tracing.path(path) {
+				traceObjects(ctx, logger.With("path", path), fetched.doc.Objects, 0)
 			}
+		}
+	}
+
+	// Process all metrics in a stable order
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-			var resp Response
-			if err := xml.Unmarshal(pathCache[source.Path], &resp); err != nil {
-				log.Printf("Failed to parse %s: %v", source.Path, err)
+	for _, name := range names {
+		metricDef := metrics[name]
+		metricName := prefix + name
+		labelNames := metricDef.LabelSchema()
+		missing := metricDef.MissingLabelValue
+		if missing == "" {
+			missing = defaultMissingLabelValue
+		}
+		for _, source := range metricDef.Sources {
+			fetched := pathCache[source.Path]
+			if fetched.err != nil {
+				// A failed request does not mean the objects are gone
+				metricStore.Retain(metricName)
 				continue
 			}
+			sourceLogger := logger.With("path", source.Path, "metric", name)
 
-			// Debug: print XML structure for pool-statistics
-			if debugMode && source.Path == "pool-statistics" && name == "tier_reads" {
-				log.Printf("DEBUG: XML structure for path %s:", source.Path)
-				printXMLStructure(resp.Objects, "  ")
+			// Find objects matching the selectors
+			objects := fetched.doc.selectObjects(source.ObjectSelector, source.ObjectBasetype)
+			if len(objects) == 0 {
+				sourceLogger.Debug("No objects found", "selector", source.ObjectSelector, "basetype", source.ObjectBasetype)
 			}
-
-			// Find objects matching the selector
-			objects := findObjects(resp.Objects, source.ObjectSelector)
-			if debugMode && len(objects) == 0 {
-				log.Printf("DEBUG: No objects found for metric %s (path: %s, selector: %s)", name, source.Path, source.ObjectSelector)
+			if filtered := filterObjects(objects, source.Filters); len(filtered) < len(objects) {
+				sourceLogger.Debug("Objects filtered out", "count", len(objects)-len(filtered))
+				objects = filtered
 			}
 
-			// Special handling for complex selectors
-			if source.ObjectSelector == "drive" && name == "disk_ssd_life_left" {
-				// Check for SSD architecture filter - only for SSD life metric
-				filtered := []Object{}
-				for _, obj := range objects {
-					if arch, ok := findProperty(obj, "architecture"); ok && arch == "SSD" {
-						filtered = append(filtered, obj)
-					}
+			for _, obj := range objects {
+				// Fill the label schema, extracted properties and static labels
+				labels := make(map[string]string, len(labelNames))
+				for _, label := range labelNames {
+					labels[label] = missing
 				}
-				if len(filtered) > 0 {
-					objects = filtered
+				maps.Copy(labels, extractLabels(*obj, source.PropertiesAsLabel))
+				for _, parent := range source.ParentLabels {
+					if ancestor, ok := fetched.doc.ancestor(obj, parent.ObjectSelector, parent.ObjectBasetype); ok {
+						maps.Copy(labels, extractLabels(*ancestor, parent.PropertiesAsLabel))
+					}
 				}
-			}
-
-			for _, obj := range objects {
-				// Extract labels
-				labels := extractLabels(obj, source.PropertiesAsLabel)
-
-				// Add static labels from source
 				for k, v := range source.Labels {
 					labels[k] = fmt.Sprint(v)
 				}
 
 				// Find the value
-				value, ok := findProperty(obj, source.PropertySelector)
+				value, ok := findProperty(*obj, source.PropertySelector)
 				if !ok {
-					if debugMode {
-						log.Printf("DEBUG: Property %s not found for metric %s", source.PropertySelector, name)
-					}
+					sourceLogger.Debug("Property not found", "property", source.PropertySelector)
 					continue
 				}
 
@@ -359,41 +909,94 @@ func scrapeMSA(client *MSAClient, metricStore *MetricStore) error {
 					if value == "NaN" {
 						floatValue = math.NaN()
 					} else {
-						log.Printf("Failed to parse value %s: %v", value, err)
+						sourceLogger.Warn("Failed to parse value", "value", value, "err", err)
 						continue
 					}
 				}
-
-				// Get label names
-				labelNames := make([]string, 0, len(labels))
-				for k := range labels {
-					labelNames = append(labelNames, k)
+				if tracing.metric(name) {
+					sourceLogger.Log(ctx, levelTrace, "Metric value", "object", obj.Name, "labels", labels, "value", floatValue)
 				}
 
 				// Set the metric
-				metric := metricStore.GetOrCreate(metricName, metricDef.Description, labelNames)
-				metric.With(labels).Set(floatValue)
+				if err := metricStore.Set(metricName, metricDef.Description, labels, floatValue); err != nil {
+					sourceLogger.Warn("Failed to set metric", "err", err)
+				}
 			}
 		}
 	}
 
+	// Drop series of objects that are gone from the array
+	if removed := metricStore.Sweep(); removed > 0 {
+		logger.Debug("Removed stale series", "count", removed)
+	}
+
 	return nil
 }
 
-var debugMode bool
+// scrapeTarget scrapes a target into metricStore using its pooled client
+func scrapeTarget(ctx context.Context, pool *clientPool, key string, target TargetConfig, auth AuthModule, metricStore *MetricStore, metrics map[string]MetricDefinition) error {
+	client, err := pool.Client(key, target, auth)
+	if err != nil {
+		return fmt.Errorf("failed to create client: %w", err)
+	}
+	return scrapeMSA(ctx, client, metricStore, metrics)
+}
+
+// stringSliceFlag is a flag.Value collecting repeated string flags
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSliceFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
 
 func main() {
 	// Parse command line arguments
+	configFile := flag.String("config.file", "", "Path to YAML configuration file")
+	var metricsFiles stringSliceFlag
+	flag.Var(&metricsFiles, "metrics.file", "Path to a metric definitions file extending the defaults (repeatable)")
 	hostname := flag.String("hostname", "", "MSA storage hostname")
 	login := flag.String("login", "", "MSA storage login")
-	password := flag.String("password", "", "MSA storage password")
-	port := flag.Int("port", 8000, "Exporter port")
+	password := flag.String("password", "", "MSA storage password (visible in the process list, prefer --password-file)")
+	passwordFile := flag.String("password-file", "", "File containing the MSA storage password, re-read after a failed login")
+	port := flag.Int("port", 8000, "Exporter port, used when --web.listen-address is not set")
+	var listenAddresses stringSliceFlag
+	flag.Var(&listenAddresses, "web.listen-address", "Address to listen on, e.g. :8000 or 192.0.2.10:8000 (repeatable)")
+	webConfigFile := flag.String("web.config.file", "", "Path to a web configuration file enabling TLS and authentication")
+	telemetryPath := flag.String("web.telemetry-path", "/metrics", "Path under which to expose metrics")
 	interval := flag.Int("interval", 60, "Scrape interval in seconds")
 	timeout := flag.Int("timeout", 60, "Scrape timeout in seconds")
-	flag.BoolVar(&debugMode, "debug", false, "Enable debug logging")
+	collectorMode := flag.String("collector.mode", modeBackground, "Collection mode for configured targets: background or ondemand")
+	cacheTTL := flag.Duration("collector.cache-ttl", 10*time.Second, "How long on-demand results are reused for concurrent scrapes")
+	insecureSkipVerify := flag.Bool("tls.insecure-skip-verify", false, "Disable certificate verification for --hostname and ad-hoc /probe targets")
+	allowAdhoc := flag.Bool("probe.allow-adhoc-targets", false, "Allow /probe to scrape HTTPS addresses that are not configured targets, using the default auth module")
+	shutdownTimeout := flag.Duration("shutdown.timeout", 15*time.Second, "Grace period for draining requests and logging out on shutdown")
+	clientIdleTimeout := flag.Duration("client.idle-timeout", 10*time.Minute, "Close sessions of probed targets and auth modules unused for this long, 0 keeps them")
+	readyMaxAge := flag.Duration("ready.max-age", 5*time.Minute, "Maximum age of the last successful scrape before /-/ready reports not ready")
+	logLevel := flag.String("log.level", "info", "Only log messages with the given severity or above: trace, debug, info, warn or error")
+	logFormat := flag.String("log.format", "logfmt", "Output format of log messages: logfmt or json")
+	var tracePaths, traceMetrics stringSliceFlag
+	flag.Var(&tracePaths, "log.trace-path", "API path whose responses are dumped at trace level (repeatable, default: all)")
+	flag.Var(&traceMetrics, "log.trace-metric", "Metric whose values are logged at trace level (repeatable, default: all)")
+	debug := flag.Bool("debug", false, "Deprecated: same as --log.level=debug")
 
 	flag.Parse()
 
+	if *debug && *logLevel == "info" {
+		*logLevel = "debug"
+	}
+	logger, err := newLogger(os.Stderr, *logLevel, *logFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+	tracing = newTraceFilter(tracePaths, traceMetrics)
+
 	// Handle positional arguments for backward compatibility
 	args := flag.Args()
 	if len(args) >= 3 {
@@ -401,6 +1004,9 @@ func main() {
 		*login = args[1]
 		*password = args[2]
 	}
+	if *password != "" {
+		slog.Warn("The password on the command line is visible in the process list, use --password-file or the PASSWORD environment variable instead")
+	}
 
 	// Read from environment variables if flags are not set
 	if *hostname == "" {
@@ -409,9 +1015,15 @@ func main() {
 	if *login == "" {
 		*login = os.Getenv("LOGIN")
 	}
-	if *password == "" {
+	if *passwordFile == "" {
+		*passwordFile = os.Getenv("PASSWORD_FILE")
+	}
+	if *password == "" && *passwordFile == "" {
 		*password = os.Getenv("PASSWORD")
 	}
+	if *password != "" && *passwordFile != "" {
+		fatal("--password and --password-file are mutually exclusive")
+	}
 	if portEnv := os.Getenv("PORT"); portEnv != "" && *port == 8000 {
 		if p, err := strconv.Atoi(portEnv); err == nil {
 			*port = p
@@ -428,47 +1040,116 @@ func main() {
 		}
 	}
 
-	if *hostname == "" || *login == "" || *password == "" {
-		log.Fatal("hostname, login, and password are required")
+	timeoutDuration := time.Duration(*timeout) * time.Second
+	intervalDuration := time.Duration(*interval) * time.Second
+
+	if err := validateTelemetryPath(*telemetryPath); err != nil {
+		fatal("Invalid --web.telemetry-path", "err", err)
+	}
+	webConfig, err := webFlags(listenAddresses, *port, *webConfigFile)
+	if err != nil {
+		fatal("Failed to load web configuration", "err", err)
 	}
 
-	fmt.Printf("Starting MSA exporter on port %d\n", *port)
-	fmt.Printf("Connecting to %s as %s\n", *hostname, *login)
-	fmt.Printf("Scraping every %d seconds with timeout %d seconds\n", *interval, *timeout)
+	if *collectorMode != modeBackground && *collectorMode != modeOnDemand {
+		fatal("Invalid collector mode, expected "+modeBackground+" or "+modeOnDemand, "mode", *collectorMode)
+	}
 
-	// Create metric store
-	metricStore := NewMetricStore()
+	state, err := NewSafeConfig(&configLoader{
+		configFile:   *configFile,
+		metricsFiles: metricsFiles,
+		hostname:     *hostname,
+		login:        *login,
+		password:     *password,
+		passwordFile: *passwordFile,
+		timeout:      timeoutDuration,
+		tlsConfig:    TLSConfig{InsecureSkipVerify: *insecureSkipVerify},
+	})
+	if err != nil {
+		fatal("Failed to load configuration", "err", err)
+	}
+	cfg, metrics := state.Get()
+
+	slog.Info("Starting MSA exporter", "addresses", strings.Join(*webConfig.WebListenAddresses, ","), "metrics", len(metrics))
+	switch {
+	case len(cfg.Targets) == 0:
+		slog.Info("No targets configured, serving /probe requests only")
+	case *collectorMode == modeOnDemand:
+		slog.Info("Scraping targets on demand", "targets", len(cfg.Targets), "cache_ttl", *cacheTTL)
+	default:
+		slog.Info("Scraping targets in the background", "targets", len(cfg.Targets), "interval", intervalDuration)
+	}
 
-	// Start Prometheus HTTP server
-	http.Handle("/metrics", promhttp.Handler())
+	// ctx is cancelled on SIGINT/SIGTERM and aborts all in-flight requests
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Every configured target gets its own registry labelled with the target name
+	pool := newClientPool()
+	manager := newTargetManager(ctx, state, pool, intervalDuration)
+	manager.mode = *collectorMode
+	manager.cacheTTL = *cacheTTL
+	manager.Sync()
+	if *clientIdleTimeout > 0 {
+		go pool.RunEviction(ctx, *clientIdleTimeout)
+	}
 
-	// Health check endpoint
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		_, _ = fmt.Fprintf(w, `{"status":"healthy","service":"msa_exporter"}`)
-	})
+	// Reload the configuration on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := manager.Reload(); err != nil {
+				slog.Error("Failed to reload configuration", "err", err)
+			} else {
+				slog.Info("Configuration reloaded")
+			}
+		}
+	}()
 
+	// Start Prometheus HTTP server. Targets are gathered first so that
+	// on-demand scrapes update msa_up before the exporter's own metrics.
+	http.Handle(*telemetryPath, promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.Gatherers{manager, prometheus.DefaultGatherer}, promhttp.HandlerOpts{}),
+	))
+	http.Handle("/probe", probeHandler(state, pool, timeoutDuration, TLSConfig{InsecureSkipVerify: *insecureSkipVerify}, *allowAdhoc))
+	http.Handle("/-/reload", reloadHandler(manager.Reload))
+
+	// Health check endpoints, /health is kept for existing deployments
+	http.HandleFunc("/health", healthyHandler)
+	http.HandleFunc("/-/healthy", healthyHandler)
+	http.Handle("/-/ready", readyHandler(manager, *readyMaxAge))
+
+	server := &http.Server{
+		// Requests such as /probe are cancelled together with the scrape loops
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
 	go func() {
-		addr := fmt.Sprintf(":%d", *port)
-		if err := http.ListenAndServe(addr, nil); err != nil {
-			log.Fatalf("Failed to start HTTP server: %v", err)
+		if err := web.ListenAndServe(server, webConfig, slog.Default()); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("Failed to start HTTP server", "err", err)
 		}
 	}()
 
-	// Main scraping loop
-	timeoutDuration := time.Duration(*timeout) * time.Second
-	intervalDuration := time.Duration(*interval) * time.Second
+	<-ctx.Done()
+	stop()
+	slog.Info("Shutting down, logging out of MSA sessions")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
 
-	for {
-		client, err := NewMSAClient(*hostname, *login, *password, timeoutDuration)
-		if err != nil {
-			log.Printf("Failed to create client: %v", err)
-		} else {
-			if err := scrapeMSA(client, metricStore); err != nil {
-				log.Printf("Failed to scrape: %v", err)
-			}
-		}
-		time.Sleep(intervalDuration)
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Failed to drain HTTP server", "err", err)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		manager.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-shutdownCtx.Done():
+		slog.Warn("Scrape loops did not stop in time", "timeout", *shutdownTimeout)
 	}
+	pool.Close(shutdownCtx)
+	slog.Info("Shutdown complete")
 }
@@ -1,73 +1,23 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
+	"crypto/tls"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
-)
-
-// Test helper functions
-func TestFindObjects(t *testing.T) {
-	tests := []struct {
-		name     string
-		objects  []Object
-		search   string
-		expected int
-	}{
-		{
-			name: "find direct object",
-			objects: []Object{
-				{Name: "test1"},
-				{Name: "test2"},
-			},
-			search:   "test1",
-			expected: 1,
-		},
-		{
-			name: "find nested objects",
-			objects: []Object{
-				{
-					Name: "parent",
-					Objects: []Object{
-						{Name: "child1"},
-						{Name: "child2"},
-					},
-				},
-			},
-			search:   "child1",
-			expected: 1,
-		},
-		{
-			name: "find multiple objects",
-			objects: []Object{
-				{Name: "disk"},
-				{Name: "disk"},
-				{Name: "volume"},
-			},
-			search:   "disk",
-			expected: 2,
-		},
-		{
-			name:     "no match",
-			objects:  []Object{{Name: "test"}},
-			search:   "nomatch",
-			expected: 0,
-		},
-	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			result := findObjects(tt.objects, tt.search)
-			if len(result) != tt.expected {
-				t.Errorf("findObjects() returned %d objects, expected %d", len(result), tt.expected)
-			}
-		})
-	}
-}
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
 
 func TestFindProperty(t *testing.T) {
 	tests := []struct {
@@ -190,31 +140,27 @@ func TestXMLParsing(t *testing.T) {
 
 // Test MetricStore
 func TestMetricStore(t *testing.T) {
-	ms := NewMetricStore()
+	ms := NewMetricStoreWithRegisterer(prometheus.NewRegistry())
 
 	t.Run("create new metric", func(t *testing.T) {
-		labels := []string{"controller", "serial"}
-		metric := ms.GetOrCreate("test_metric", "Test metric description", labels)
-
-		if metric == nil {
-			t.Fatal("GetOrCreate returned nil")
+		labels := map[string]string{"controller": "A", "serial": "SN1"}
+		if err := ms.Set("test_metric", "Test metric description", labels, 1); err != nil {
+			t.Fatalf("Set failed: %v", err)
 		}
 
-		// Try to get the same metric again
-		metric2 := ms.GetOrCreate("test_metric", "Test metric description", labels)
-		if metric2 != metric {
-			t.Error("GetOrCreate should return the same metric instance")
+		// Setting the metric again reuses the registered gauge
+		if err := ms.Set("test_metric", "Test metric description", labels, 2); err != nil {
+			t.Fatalf("Set of an existing metric failed: %v", err)
+		}
+		if v := testutil.ToFloat64(ms.metrics["test_metric"].With(labels)); v != 2 {
+			t.Errorf("Expected value 2, got %v", v)
 		}
 	})
 
-	t.Run("set metric value", func(t *testing.T) {
-		labels := []string{"host"}
-		metric := ms.GetOrCreate("test_gauge", "Test gauge", labels)
-
-		labelValues := map[string]string{"host": "localhost"}
-		metric.With(labelValues).Set(42.5)
-
-		// Metric is set successfully if no panic occurs
+	t.Run("labels must match the schema", func(t *testing.T) {
+		if err := ms.Set("test_metric", "Test metric description", map[string]string{"host": "localhost"}, 1); err == nil {
+			t.Error("Expected an error for labels not matching the schema")
+		}
 	})
 }
 
@@ -265,7 +211,7 @@ func TestMSAClient(t *testing.T) {
 	host := server.URL[8:]
 
 	t.Run("authentication", func(t *testing.T) {
-		client, err := NewMSAClient(host, "testuser", "testpass", 10*time.Second)
+		client, err := newLoggedInClient(t.Context(), host, "testuser", "testpass", 10*time.Second, &tls.Config{InsecureSkipVerify: true})
 		if err != nil {
 			t.Fatalf("Failed to create client: %v", err)
 		}
@@ -280,51 +226,55 @@ func TestMSAClient(t *testing.T) {
 	})
 
 	t.Run("get data", func(t *testing.T) {
-		client, err := NewMSAClient(host, "testuser", "testpass", 10*time.Second)
+		client, err := newLoggedInClient(t.Context(), host, "testuser", "testpass", 10*time.Second, &tls.Config{InsecureSkipVerify: true})
 		if err != nil {
 			t.Fatalf("Failed to create client: %v", err)
 		}
 
-		data, err := client.Get("version")
+		doc, err := client.GetDocument(t.Context(), "version")
 		if err != nil {
 			t.Fatalf("Failed to get version: %v", err)
 		}
 
-		if len(data) == 0 {
-			t.Error("Response data is empty")
-		}
-
-		var response Response
-		err = xml.Unmarshal(data, &response)
-		if err != nil {
-			t.Fatalf("Failed to parse response: %v", err)
-		}
-
-		if len(response.Objects) == 0 {
+		if len(doc.Objects) == 0 {
 			t.Error("No objects in response")
 		}
 	})
 
 	t.Run("authentication failure - wrong credentials", func(t *testing.T) {
-		_, err := NewMSAClient(host, "wronguser", "wrongpass", 10*time.Second)
+		_, err := newLoggedInClient(t.Context(), host, "wronguser", "wrongpass", 10*time.Second, &tls.Config{InsecureSkipVerify: true})
 		if err == nil {
 			t.Error("Expected authentication to fail with wrong credentials")
 		}
 	})
 
 	t.Run("get data - not found", func(t *testing.T) {
-		client, err := NewMSAClient(host, "testuser", "testpass", 10*time.Second)
+		client, err := newLoggedInClient(t.Context(), host, "testuser", "testpass", 10*time.Second, &tls.Config{InsecureSkipVerify: true})
 		if err != nil {
 			t.Fatalf("Failed to create client: %v", err)
 		}
 
-		_, err = client.Get("nonexistent")
+		_, err = client.GetDocument(t.Context(), "nonexistent")
 		if err == nil {
 			t.Error("Expected Get to fail with non-existent endpoint")
 		}
 	})
 }
 
+// newLoggedInClient creates a client for an address given like a target's
+// host and logs in
+func newLoggedInClient(ctx context.Context, host, login, password string, timeout time.Duration, tlsConfig *tls.Config) (*MSAClient, error) {
+	address, err := normalizeAddress(host)
+	if err != nil {
+		return nil, err
+	}
+	client := newMSAClient(address, login, password, timeout, tlsConfig)
+	if err := client.Login(ctx); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
 // Helper function for SHA256 (for testing)
 func getSHA256(s string) string {
 	hash := sha256.Sum256([]byte(s))
@@ -404,7 +354,7 @@ func TestScrapeMSA(t *testing.T) {
 			w.WriteHeader(http.StatusOK)
 			_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
 <RESPONSE>
-	<OBJECT name="pools">
+	<OBJECT basetype="pools" name="pool" oid="1">
 		<PROPERTY name="name">pool1</PROPERTY>
 		<PROPERTY name="serial-number">POOL123</PROPERTY>
 		<PROPERTY name="total-size-numeric">10000000</PROPERTY>
@@ -421,16 +371,16 @@ func TestScrapeMSA(t *testing.T) {
 	host := server.URL[8:]
 
 	// Create client once for all tests
-	client, err := NewMSAClient(host, "testuser", "testpass", 10*time.Second)
+	client, err := newLoggedInClient(t.Context(), host, "testuser", "testpass", 10*time.Second, &tls.Config{InsecureSkipVerify: true})
 	if err != nil {
 		t.Fatalf("Failed to create client: %v", err)
 	}
 
-	// Create one metric store for all tests (metrics are registered globally)
-	ms := NewMetricStore()
+	// Create one metric store shared by the subtests
+	ms := NewMetricStoreWithRegisterer(prometheus.NewRegistry())
 
 	t.Run("scrape metrics", func(t *testing.T) {
-		err = scrapeMSA(client, ms)
+		err = scrapeMSA(t.Context(), client, ms, getMetrics())
 		if err != nil {
 			t.Fatalf("scrapeMSA failed: %v", err)
 		}
@@ -535,30 +485,7 @@ func TestRecursiveFindProperty(t *testing.T) {
 	}
 }
 
-// Test printXMLStructure - mainly for coverage
-func TestPrintXMLStructure(t *testing.T) {
-	objects := []Object{
-		{
-			Name: "parent",
-			Properties: []Property{
-				{Name: "prop1", Value: "val1"},
-			},
-			Objects: []Object{
-				{
-					Name: "child",
-					Properties: []Property{
-						{Name: "prop2", Value: "val2"},
-					},
-				},
-			},
-		},
-	}
-
-	// Just call the function to ensure it doesn't panic
-	printXMLStructure(objects, "")
-}
-
-// Test NewMSAClient error cases
+// Test login error cases
 func TestNewMSAClientErrors(t *testing.T) {
 	t.Run("invalid XML response", func(t *testing.T) {
 		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -568,7 +495,7 @@ func TestNewMSAClientErrors(t *testing.T) {
 		defer server.Close()
 
 		host := server.URL[8:]
-		_, err := NewMSAClient(host, "test", "test", 10*time.Second)
+		_, err := newLoggedInClient(t.Context(), host, "test", "test", 10*time.Second, &tls.Config{InsecureSkipVerify: true})
 		if err == nil {
 			t.Error("Expected error for invalid XML response")
 		}
@@ -587,7 +514,7 @@ func TestNewMSAClientErrors(t *testing.T) {
 		defer server.Close()
 
 		host := server.URL[8:]
-		_, err := NewMSAClient(host, "test", "test", 10*time.Second)
+		_, err := newLoggedInClient(t.Context(), host, "test", "test", 10*time.Second, &tls.Config{InsecureSkipVerify: true})
 		if err == nil {
 			t.Error("Expected error for missing session key")
 		}
@@ -603,7 +530,7 @@ func TestNewMSAClientErrors(t *testing.T) {
 		defer server.Close()
 
 		host := server.URL[8:]
-		_, err := NewMSAClient(host, "test", "test", 10*time.Second)
+		_, err := newLoggedInClient(t.Context(), host, "test", "test", 10*time.Second, &tls.Config{InsecureSkipVerify: true})
 		if err == nil {
 			t.Error("Expected error for non-OK status")
 		}
@@ -629,13 +556,13 @@ func TestScrapeMSAErrors(t *testing.T) {
 		defer server.Close()
 
 		host := server.URL[8:]
-		client, err := NewMSAClient(host, "testerr1", "testerr1", 10*time.Second)
+		client, err := newLoggedInClient(t.Context(), host, "testerr1", "testerr1", 10*time.Second, &tls.Config{InsecureSkipVerify: true})
 		if err != nil {
 			t.Fatalf("Failed to create client: %v", err)
 		}
 
-		ms := NewMetricStore()
-		err = scrapeMSA(client, ms)
+		ms := NewMetricStoreWithRegisterer(prometheus.NewRegistry())
+		err = scrapeMSA(t.Context(), client, ms, getMetrics())
 		if err == nil {
 			t.Error("Expected error when version fetch fails")
 		}
@@ -659,13 +586,13 @@ func TestScrapeMSAErrors(t *testing.T) {
 		defer server.Close()
 
 		host := server.URL[8:]
-		client, err := NewMSAClient(host, "testerr2", "testerr2", 10*time.Second)
+		client, err := newLoggedInClient(t.Context(), host, "testerr2", "testerr2", 10*time.Second, &tls.Config{InsecureSkipVerify: true})
 		if err != nil {
 			t.Fatalf("Failed to create client: %v", err)
 		}
 
-		ms := NewMetricStore()
-		err = scrapeMSA(client, ms)
+		ms := NewMetricStoreWithRegisterer(prometheus.NewRegistry())
+		err = scrapeMSA(t.Context(), client, ms, getMetrics())
 		if err == nil {
 			t.Error("Expected error when version XML is invalid")
 		}
@@ -674,11 +601,7 @@ func TestScrapeMSAErrors(t *testing.T) {
 
 // Test health check endpoint
 func TestHealthCheckEndpoint(t *testing.T) {
-	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		_, _ = fmt.Fprintf(w, `{"status":"healthy","service":"msa_exporter"}`)
-	})
+	handler := http.HandlerFunc(healthyHandler)
 
 	req, err := http.NewRequest("GET", "/health", nil)
 	if err != nil {
@@ -702,3 +625,517 @@ func TestHealthCheckEndpoint(t *testing.T) {
 		t.Errorf("handler returned wrong content type: got %v want %v", contentType, "application/json")
 	}
 }
+
+// Test session reuse and transparent re-authentication
+func TestMSAClientSessionReuse(t *testing.T) {
+	var mu sync.Mutex
+	logins := 0
+	validKey := ""
+	expireWith := ""
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.URL.Path == "/api/login/"+getSHA256("sessuser_sesspass") {
+			logins++
+			validKey = fmt.Sprintf("session-%d", logins)
+			_, _ = fmt.Fprintf(w, `<RESPONSE><OBJECT name="status"><PROPERTY name="response">%s</PROPERTY></OBJECT></RESPONSE>`, validKey)
+			return
+		}
+
+		if r.Header.Get("sessionKey") != validKey || expireWith != "" {
+			mode := expireWith
+			expireWith = ""
+			if mode == "xml" {
+				_, _ = w.Write([]byte(`<RESPONSE><OBJECT name="status" basetype="status">
+	<PROPERTY name="response-type">Error</PROPERTY>
+	<PROPERTY name="response">Invalid sessionkey</PROPERTY>
+</OBJECT></RESPONSE>`))
+				return
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		_, _ = w.Write([]byte(`<RESPONSE><OBJECT name="status"><PROPERTY name="response-type">Success</PROPERTY></OBJECT></RESPONSE>`))
+	}))
+	defer server.Close()
+
+	client := newMSAClient(server.URL[8:], "sessuser", "sesspass", 10*time.Second, &tls.Config{InsecureSkipVerify: true})
+
+	t.Run("lazy login and reuse", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			if _, err := client.GetDocument(t.Context(), "system"); err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+		}
+		if logins != 1 {
+			t.Errorf("Expected 1 login for 3 requests, got %d", logins)
+		}
+	})
+
+	t.Run("re-login on HTTP status", func(t *testing.T) {
+		mu.Lock()
+		expireWith = "http"
+		mu.Unlock()
+
+		if _, err := client.GetDocument(t.Context(), "system"); err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if logins != 2 {
+			t.Errorf("Expected 2 logins, got %d", logins)
+		}
+		if client.sessionKey != "session-2" {
+			t.Errorf("Expected new session key, got %s", client.sessionKey)
+		}
+	})
+
+	t.Run("re-login on error status object", func(t *testing.T) {
+		mu.Lock()
+		expireWith = "xml"
+		mu.Unlock()
+
+		if _, err := client.GetDocument(t.Context(), "system"); err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if logins != 3 {
+			t.Errorf("Expected 3 logins, got %d", logins)
+		}
+	})
+
+	t.Run("concurrent requests share one re-login", func(t *testing.T) {
+		mu.Lock()
+		validKey = "revoked"
+		mu.Unlock()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, _ = client.GetDocument(t.Context(), "system")
+			}()
+		}
+		wg.Wait()
+
+		mu.Lock()
+		defer mu.Unlock()
+		if logins != 4 {
+			t.Errorf("Expected 4 logins, got %d", logins)
+		}
+	})
+}
+
+func TestMSAClientSessionRetryOnce(t *testing.T) {
+	logins := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/login/"+getSHA256("sessuser_sesspass") {
+			logins++
+			_, _ = w.Write([]byte(`<RESPONSE><OBJECT name="status"><PROPERTY name="response">key</PROPERTY></OBJECT></RESPONSE>`))
+			return
+		}
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	client := newMSAClient(server.URL[8:], "sessuser", "sesspass", 10*time.Second, &tls.Config{InsecureSkipVerify: true})
+	if _, err := client.GetDocument(t.Context(), "system"); err == nil {
+		t.Error("Expected error when the session is rejected after re-login")
+	}
+	if logins != 2 {
+		t.Errorf("Expected exactly one re-login, got %d logins", logins)
+	}
+}
+
+func TestMetricStoreMetrics(t *testing.T) {
+	ms := NewMetricStoreWithRegisterer(prometheus.NewRegistry())
+	ms.Set("test_a", "Test A", map[string]string{"id": "1"}, 1)
+	ms.Set("test_a", "Test A", map[string]string{"id": "2"}, 2)
+	ms.Set("test_b", "Test B", nil, 3)
+
+	if n := len(ms.Metrics()); n != 3 {
+		t.Errorf("Expected 3 samples, got %d", n)
+	}
+}
+
+func TestMetricStoreSweep(t *testing.T) {
+	ms := NewMetricStoreWithRegisterer(prometheus.NewRegistry())
+
+	ms.BeginScrape()
+	ms.Set("test_volume_size", "Size", map[string]string{"volume": "vol1"}, 1)
+	ms.Set("test_volume_size", "Size", map[string]string{"volume": "vol2"}, 2)
+	if removed := ms.Sweep(); removed != 0 {
+		t.Errorf("Expected no removed series after first scrape, got %d", removed)
+	}
+
+	ms.BeginScrape()
+	ms.Set("test_volume_size", "Size", map[string]string{"volume": "vol2"}, 3)
+	if removed := ms.Sweep(); removed != 1 {
+		t.Errorf("Expected 1 removed series, got %d", removed)
+	}
+
+	vec := ms.metrics["test_volume_size"]
+	if n := testutil.CollectAndCount(vec); n != 1 {
+		t.Errorf("Expected 1 remaining series, got %d", n)
+	}
+	if v := testutil.ToFloat64(vec.With(map[string]string{"volume": "vol2"})); v != 3 {
+		t.Errorf("Expected vol2 value 3, got %v", v)
+	}
+}
+
+func TestScrapeMSARemovesStaleSeries(t *testing.T) {
+	var mu sync.Mutex
+	serials := []string{"OLD123", "KEEP456"}
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/login/" + getSHA256("staleuser_stalepass"):
+			_, _ = w.Write([]byte(`<RESPONSE><OBJECT name="status"><PROPERTY name="response">key</PROPERTY></OBJECT></RESPONSE>`))
+		case "/api/show/disks":
+			mu.Lock()
+			defer mu.Unlock()
+			_, _ = w.Write([]byte("<RESPONSE>"))
+			for i, serial := range serials {
+				_, _ = fmt.Fprintf(w, `<OBJECT name="drive">
+	<PROPERTY name="location">1.%d</PROPERTY>
+	<PROPERTY name="serial-number">%s</PROPERTY>
+	<PROPERTY name="temperature-numeric">40</PROPERTY>
+</OBJECT>`, i, serial)
+			}
+			_, _ = w.Write([]byte("</RESPONSE>"))
+		default:
+			_, _ = w.Write([]byte(`<RESPONSE></RESPONSE>`))
+		}
+	}))
+	defer server.Close()
+
+	client := newMSAClient(server.URL[8:], "staleuser", "stalepass", 10*time.Second, &tls.Config{InsecureSkipVerify: true})
+	ms := NewMetricStoreWithRegisterer(prometheus.NewRegistry())
+
+	if err := scrapeMSA(t.Context(), client, ms, getMetrics()); err != nil {
+		t.Fatalf("scrapeMSA failed: %v", err)
+	}
+	if n := testutil.CollectAndCount(ms.metrics["msa_disk_temperature"]); n != 2 {
+		t.Fatalf("Expected 2 disk series, got %d", n)
+	}
+
+	// Replace the old disk
+	mu.Lock()
+	serials = []string{"NEW789", "KEEP456"}
+	mu.Unlock()
+
+	if err := scrapeMSA(t.Context(), client, ms, getMetrics()); err != nil {
+		t.Fatalf("scrapeMSA failed: %v", err)
+	}
+
+	expected := `
+# HELP msa_disk_temperature Temperature
+# TYPE msa_disk_temperature gauge
+msa_disk_temperature{location="1.0",serial="NEW789"} 40
+msa_disk_temperature{location="1.1",serial="KEEP456"} 40
+`
+	if err := testutil.CollectAndCompare(ms.metrics["msa_disk_temperature"], strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestScrapeMSAKeepsSeriesOfFailedPaths(t *testing.T) {
+	var failing atomic.Bool
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/login/" + getSHA256("failuser_failpass"):
+			_, _ = w.Write([]byte(`<RESPONSE><OBJECT name="status"><PROPERTY name="response">key</PROPERTY></OBJECT></RESPONSE>`))
+		case "/api/show/disks":
+			if failing.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = w.Write([]byte(`<RESPONSE><OBJECT name="drive"><PROPERTY name="location">1.1</PROPERTY><PROPERTY name="serial-number">S1</PROPERTY><PROPERTY name="temperature-numeric">40</PROPERTY></OBJECT></RESPONSE>`))
+		case "/api/show/volumes":
+			if failing.Load() {
+				_, _ = w.Write([]byte(`<RESPONSE></RESPONSE>`))
+				return
+			}
+			_, _ = w.Write([]byte(`<RESPONSE><OBJECT name="volume"><PROPERTY name="volume-name">vol1</PROPERTY><PROPERTY name="health-numeric">0</PROPERTY></OBJECT></RESPONSE>`))
+		default:
+			_, _ = w.Write([]byte(`<RESPONSE></RESPONSE>`))
+		}
+	}))
+	defer server.Close()
+
+	client := newMSAClient(server.URL[8:], "failuser", "failpass", 10*time.Second, &tls.Config{InsecureSkipVerify: true})
+	ms := NewMetricStoreWithRegisterer(prometheus.NewRegistry())
+
+	if err := scrapeMSA(t.Context(), client, ms, getMetrics()); err != nil {
+		t.Fatalf("scrapeMSA failed: %v", err)
+	}
+
+	// The disks path fails while the volume is really gone
+	failing.Store(true)
+	if err := scrapeMSA(t.Context(), client, ms, getMetrics()); err != nil {
+		t.Fatalf("scrapeMSA failed: %v", err)
+	}
+
+	if n := testutil.CollectAndCount(ms.metrics["msa_disk_temperature"]); n != 1 {
+		t.Errorf("Expected the disk series to be kept, got %d series", n)
+	}
+	if n := testutil.CollectAndCount(ms.metrics["msa_volume_health"]); n != 0 {
+		t.Errorf("Expected the volume series to be removed, got %d series", n)
+	}
+}
+
+func TestMetricStoreSetSchemaMismatch(t *testing.T) {
+	ms := NewMetricStoreWithRegisterer(prometheus.NewRegistry())
+
+	if err := ms.Set("test_version", "Version", map[string]string{"controller": "a", "pld_rev": "1"}, 1); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := ms.Set("test_version", "Version", map[string]string{"controller": "b"}, 1); err == nil {
+		t.Error("Expected error for labels not matching the schema")
+	}
+	if n := testutil.CollectAndCount(ms.metrics["test_version"]); n != 1 {
+		t.Errorf("Expected 1 series, got %d", n)
+	}
+}
+
+func TestScrapeMSAMissingLabelProperty(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/login/" + getSHA256("partialuser_partialpass"):
+			_, _ = w.Write([]byte(`<RESPONSE><OBJECT name="status"><PROPERTY name="response">key</PROPERTY></OBJECT></RESPONSE>`))
+		case "/api/show/version":
+			// Controller B reports no pld-rev
+			_, _ = w.Write([]byte(`<RESPONSE>
+	<OBJECT name="controller-a-versions"><PROPERTY name="bundle-version">GT280</PROPERTY><PROPERTY name="pld-rev">27</PROPERTY></OBJECT>
+	<OBJECT name="controller-b-versions"><PROPERTY name="bundle-version">GT280</PROPERTY></OBJECT>
+</RESPONSE>`))
+		case "/api/show/disks":
+			_, _ = w.Write([]byte(`<RESPONSE>
+	<OBJECT name="drive"><PROPERTY name="location">1.1</PROPERTY><PROPERTY name="serial-number">S1</PROPERTY><PROPERTY name="temperature-numeric">40</PROPERTY></OBJECT>
+	<OBJECT name="drive"><PROPERTY name="location">1.2</PROPERTY><PROPERTY name="temperature-numeric">41</PROPERTY></OBJECT>
+</RESPONSE>`))
+		default:
+			_, _ = w.Write([]byte(`<RESPONSE></RESPONSE>`))
+		}
+	}))
+	defer server.Close()
+
+	client := newMSAClient(server.URL[8:], "partialuser", "partialpass", 10*time.Second, &tls.Config{InsecureSkipVerify: true})
+	ms := NewMetricStoreWithRegisterer(prometheus.NewRegistry())
+
+	if err := scrapeMSA(t.Context(), client, ms, getMetrics()); err != nil {
+		t.Fatalf("scrapeMSA failed: %v", err)
+	}
+
+	expected := `
+# HELP msa_disk_temperature Temperature
+# TYPE msa_disk_temperature gauge
+msa_disk_temperature{location="1.1",serial="S1"} 40
+msa_disk_temperature{location="1.2",serial="unknown"} 41
+`
+	if err := testutil.CollectAndCompare(ms.metrics["msa_disk_temperature"], strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	expected = `
+# HELP msa_version Firmware Versions
+# TYPE msa_version gauge
+msa_version{bundle_base_version="unknown",bundle_version="GT280",controller="controller-a-versions",mc_fw="unknown",pld_rev="27",sc_fw="unknown"} 1
+msa_version{bundle_base_version="unknown",bundle_version="GT280",controller="controller-b-versions",mc_fw="unknown",pld_rev="unknown",sc_fw="unknown"} 1
+`
+	if err := testutil.CollectAndCompare(ms.metrics["msa_version"], strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestScrapeMSAObjectBasetype(t *testing.T) {
+	// Firmware versions name pool objects differently but share the basetype
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/login/" + getSHA256("pooluser_poolpass"):
+			_, _ = w.Write([]byte(`<RESPONSE><OBJECT name="status"><PROPERTY name="response">key</PROPERTY></OBJECT></RESPONSE>`))
+		case "/api/show/pools":
+			_, _ = w.Write([]byte(`<RESPONSE>
+	<OBJECT basetype="pools" name="pool" oid="1"><PROPERTY name="name">A</PROPERTY><PROPERTY name="serial-number">S1</PROPERTY><PROPERTY name="total-size-numeric">100</PROPERTY></OBJECT>
+	<OBJECT basetype="pools" name="pools" oid="2"><PROPERTY name="name">B</PROPERTY><PROPERTY name="serial-number">S2</PROPERTY><PROPERTY name="total-size-numeric">200</PROPERTY></OBJECT>
+	<OBJECT basetype="status" name="status" oid="3"><PROPERTY name="response-type">Success</PROPERTY></OBJECT>
+</RESPONSE>`))
+		default:
+			_, _ = w.Write([]byte(`<RESPONSE></RESPONSE>`))
+		}
+	}))
+	defer server.Close()
+
+	client := newMSAClient(server.URL[8:], "pooluser", "poolpass", 10*time.Second, &tls.Config{InsecureSkipVerify: true})
+	ms := NewMetricStoreWithRegisterer(prometheus.NewRegistry())
+
+	if err := scrapeMSA(t.Context(), client, ms, getMetrics()); err != nil {
+		t.Fatalf("scrapeMSA failed: %v", err)
+	}
+
+	expected := `
+# HELP msa_pool_total_size Total Size
+# TYPE msa_pool_total_size gauge
+msa_pool_total_size{pool="A",serial="S1"} 100
+msa_pool_total_size{pool="B",serial="S2"} 200
+`
+	if err := testutil.CollectAndCompare(ms.metrics["msa_pool_total_size"], strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestScrapeMSAParentLabels(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/login/" + getSHA256("parentuser_parentpass"):
+			_, _ = w.Write([]byte(`<RESPONSE><OBJECT name="status"><PROPERTY name="response">key</PROPERTY></OBJECT></RESPONSE>`))
+		case "/api/show/pool-statistics":
+			// Tiers do not repeat the pool they belong to
+			_, _ = w.Write([]byte(`<RESPONSE>
+	<OBJECT basetype="pool-statistics" name="pool-statistics" oid="1">
+		<PROPERTY name="pool">A</PROPERTY><PROPERTY name="serial-number">SA</PROPERTY>
+		<OBJECT basetype="tier-statistics" name="tier-statistics" oid="2"><PROPERTY name="tier">Performance</PROPERTY><PROPERTY name="number-of-reads">10</PROPERTY></OBJECT>
+	</OBJECT>
+	<OBJECT basetype="pool-statistics" name="pool-statistics" oid="3">
+		<PROPERTY name="pool">B</PROPERTY><PROPERTY name="serial-number">SB</PROPERTY>
+		<OBJECT basetype="tier-statistics" name="tier-statistics" oid="4"><PROPERTY name="tier">Performance</PROPERTY><PROPERTY name="number-of-reads">20</PROPERTY></OBJECT>
+	</OBJECT>
+</RESPONSE>`))
+		case "/api/show/enclosure":
+			// Power supplies are linked to their enclosure by COMP elements
+			_, _ = w.Write([]byte(`<RESPONSE>
+	<OBJECT basetype="enclosures" name="enclosure" oid="1"><PROPERTY name="enclosure-id">0</PROPERTY></OBJECT>
+	<OBJECT basetype="power-supplies" name="power-supplies" oid="2"><PROPERTY name="durable-id">psu_0.0</PROPERTY><PROPERTY name="serial-number">P1</PROPERTY><PROPERTY name="health-numeric">0</PROPERTY></OBJECT>
+	<OBJECT basetype="enclosures" name="enclosure" oid="3"><PROPERTY name="enclosure-id">1</PROPERTY></OBJECT>
+	<OBJECT basetype="power-supplies" name="power-supplies" oid="4"><PROPERTY name="durable-id">psu_1.0</PROPERTY><PROPERTY name="serial-number">P2</PROPERTY><PROPERTY name="health-numeric">2</PROPERTY></OBJECT>
+	<COMP G="0" P="1"/><COMP G="1" P="2"/><COMP G="0" P="3"/><COMP G="3" P="4"/>
+</RESPONSE>`))
+		default:
+			_, _ = w.Write([]byte(`<RESPONSE></RESPONSE>`))
+		}
+	}))
+	defer server.Close()
+
+	client := newMSAClient(server.URL[8:], "parentuser", "parentpass", 10*time.Second, &tls.Config{InsecureSkipVerify: true})
+	ms := NewMetricStoreWithRegisterer(prometheus.NewRegistry())
+
+	if err := scrapeMSA(t.Context(), client, ms, getMetrics()); err != nil {
+		t.Fatalf("scrapeMSA failed: %v", err)
+	}
+
+	expected := `
+# HELP msa_tier_reads Reads
+# TYPE msa_tier_reads gauge
+msa_tier_reads{pool="A",serial="SA",tier="Performance"} 10
+msa_tier_reads{pool="B",serial="SB",tier="Performance"} 20
+`
+	if err := testutil.CollectAndCompare(ms.metrics["msa_tier_reads"], strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	expected = `
+# HELP msa_psu_health Power-supply unit health
+# TYPE msa_psu_health gauge
+msa_psu_health{enclosure="0",psu="psu_0.0",serial="P1"} 0
+msa_psu_health{enclosure="1",psu="psu_1.0",serial="P2"} 2
+`
+	if err := testutil.CollectAndCompare(ms.metrics["msa_psu_health"], strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestScrapeMSAFilters(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/login/" + getSHA256("filteruser_filterpass"):
+			_, _ = w.Write([]byte(`<RESPONSE><OBJECT name="status"><PROPERTY name="response">key</PROPERTY></OBJECT></RESPONSE>`))
+		case "/api/show/disks":
+			_, _ = w.Write([]byte(`<RESPONSE>
+	<OBJECT basetype="drives" name="drive"><PROPERTY name="location">0.1</PROPERTY><PROPERTY name="serial-number">S1</PROPERTY><PROPERTY name="enclosure-id">0</PROPERTY><PROPERTY name="architecture">SSD</PROPERTY><PROPERTY name="ssd-life-left-numeric">95</PROPERTY><PROPERTY name="temperature-numeric">30</PROPERTY></OBJECT>
+	<OBJECT basetype="drives" name="drive"><PROPERTY name="location">0.2</PROPERTY><PROPERTY name="serial-number">S2</PROPERTY><PROPERTY name="enclosure-id">0</PROPERTY><PROPERTY name="architecture">HDD</PROPERTY><PROPERTY name="ssd-life-left-numeric">255</PROPERTY><PROPERTY name="temperature-numeric">35</PROPERTY></OBJECT>
+	<OBJECT basetype="drives" name="drive"><PROPERTY name="location">1.1</PROPERTY><PROPERTY name="serial-number">S3</PROPERTY><PROPERTY name="enclosure-id">1</PROPERTY><PROPERTY name="architecture">SSD</PROPERTY><PROPERTY name="ssd-life-left-numeric">80</PROPERTY><PROPERTY name="temperature-numeric">40</PROPERTY></OBJECT>
+</RESPONSE>`))
+		default:
+			_, _ = w.Write([]byte(`<RESPONSE></RESPONSE>`))
+		}
+	}))
+	defer server.Close()
+
+	// Export disk temperatures of the first enclosure only
+	custom, err := ParseMetricDefinitions([]byte(`
+metrics:
+  disk_temperature:
+    description: Temperature
+    sources:
+      - path: disks
+        object_selector: drive
+        filters:
+          - property: enclosure-id
+            equals: "0"
+        property_selector: temperature-numeric
+        properties_as_label:
+          location: location
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := map[string]MetricDefinition{
+		"disk_ssd_life_left": getMetrics()["disk_ssd_life_left"],
+		"disk_temperature":   custom["disk_temperature"],
+	}
+
+	client := newMSAClient(server.URL[8:], "filteruser", "filterpass", 10*time.Second, &tls.Config{InsecureSkipVerify: true})
+	ms := NewMetricStoreWithRegisterer(prometheus.NewRegistry())
+
+	if err := scrapeMSA(t.Context(), client, ms, metrics); err != nil {
+		t.Fatalf("scrapeMSA failed: %v", err)
+	}
+
+	expected := `
+# HELP msa_disk_ssd_life_left SSD Life Remaining
+# TYPE msa_disk_ssd_life_left gauge
+msa_disk_ssd_life_left{location="0.1",serial="S1"} 95
+msa_disk_ssd_life_left{location="1.1",serial="S3"} 80
+`
+	if err := testutil.CollectAndCompare(ms.metrics["msa_disk_ssd_life_left"], strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	expected = `
+# HELP msa_disk_temperature Temperature
+# TYPE msa_disk_temperature gauge
+msa_disk_temperature{location="0.1"} 30
+msa_disk_temperature{location="0.2"} 35
+`
+	if err := testutil.CollectAndCompare(ms.metrics["msa_disk_temperature"], strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestScrapeMSALogsInOnce(t *testing.T) {
+	var logins, requests atomic.Int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/login/") {
+			logins.Add(1)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		requests.Add(1)
+		_, _ = w.Write([]byte(`<RESPONSE></RESPONSE>`))
+	}))
+	defer server.Close()
+
+	// Without a circuit breaker every path worker used to log in on its own
+	client := newMSAClient(server.URL[8:], "floodtest", "wrong", 10*time.Second, &tls.Config{InsecureSkipVerify: true})
+	err := scrapeMSA(t.Context(), client, NewMetricStoreWithRegisterer(prometheus.NewRegistry()), getMetrics())
+	if !errors.Is(err, errLoginFailed) {
+		t.Fatalf("Expected login error, got %v", err)
+	}
+	if n := logins.Load(); n != 1 {
+		t.Errorf("Expected 1 login attempt per scrape, got %d", n)
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("Expected no paths to be fetched without a session, got %d", n)
+	}
+}
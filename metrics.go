@@ -1,779 +1,218 @@
 package main
 
-// getMetrics returns all metric definitions
+import (
+	_ "embed"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"go.yaml.in/yaml/v2"
+)
+
+// defaultMetricsYAML holds the metric definitions shipped with the exporter
+//
+//go:embed metrics.yml
+var defaultMetricsYAML []byte
+
+// metricNameRE matches metric names that are valid once prefixed
+var metricNameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// defaultMissingLabelValue is the label value used when an object lacks a labelled property
+const defaultMissingLabelValue = "unknown"
+
+// reservedMetricNames are metrics produced by the exporter itself, see
+// instrumentation.go, reload.go and probeHandler. A metric definition with
+// one of these names would break gathering of the default registry.
+var reservedMetricNames = map[string]bool{
+	"version":                                               true,
+	"up":                                                    true,
+	"scrape_duration_seconds":                               true,
+	"scrape_last_success_timestamp_seconds":                 true,
+	"api_request_duration_seconds":                          true,
+	"api_request_duration_seconds_bucket":                   true,
+	"api_request_duration_seconds_sum":                      true,
+	"api_request_duration_seconds_count":                    true,
+	"api_request_errors_total":                              true,
+	"login_failures_total":                                  true,
+	"login_circuit_state":                                   true,
+	"controller_active":                                     true,
+	"exporter_config_last_reload_successful":                true,
+	"exporter_config_last_reload_success_timestamp_seconds": true,
+}
+
+// versionLabels maps the firmware properties of msa_version to their labels
+var versionLabels = map[string]string{
+	"bundle-version":      "bundle_version",
+	"bundle-base-version": "bundle_base_version",
+	"sc-fw":               "sc_fw",
+	"mc-fw":               "mc_fw",
+	"pld-rev":             "pld_rev",
+}
+
+// MetricsFile is the format of a metric definitions file.
+// Definitions are not used directly; they hold YAML anchors that are
+// shared between sources, whatever their shape.
+type MetricsFile struct {
+	Definitions map[string]interface{}      `yaml:"definitions"`
+	Metrics     map[string]MetricDefinition `yaml:"metrics"`
+}
+
+var defaultMetrics = sync.OnceValues(func() (map[string]MetricDefinition, error) {
+	return ParseMetricDefinitions(defaultMetricsYAML)
+})
+
+// getMetrics returns the default metric definitions
 func getMetrics() map[string]MetricDefinition {
-	// Label mappings
-	hostportStatsLabels := map[string]string{"durable-id": "port"}
-	diskLabels := map[string]string{"location": "location", "serial-number": "serial"}
-	volumeLabels := map[string]string{"volume-name": "volume"}
-	poolStatsLabels := map[string]string{"pool": "pool", "serial-number": "serial"}
-	poolLabels := map[string]string{"name": "pool", "serial-number": "serial"}
-	tierLabels := map[string]string{"tier": "tier", "pool": "pool", "serial-number": "serial"}
-	controllerLabels := map[string]string{"durable-id": "controller"}
-	psuLabels := map[string]string{"durable-id": "psu", "serial-number": "serial"}
+	metrics, err := defaultMetrics()
+	if err != nil {
+		panic(fmt.Sprintf("invalid embedded metric definitions: %v", err))
+	}
+	return metrics
+}
+
+// ParseMetricDefinitions parses and validates metric definitions in YAML or JSON
+func ParseMetricDefinitions(content []byte) (map[string]MetricDefinition, error) {
+	var file MetricsFile
+	if err := yaml.UnmarshalStrict(content, &file); err != nil {
+		return nil, err
+	}
+	for name, metric := range file.Metrics {
+		if err := validateMetricDefinition(name, metric); err != nil {
+			return nil, err
+		}
+	}
+	return file.Metrics, nil
+}
 
-	return map[string]MetricDefinition{
-		"hostport_data_read": {
-			Description: "Data Read",
-			Sources: []MetricSource{{
-				Path:              "host-port-statistics",
-				ObjectSelector:    "host-port-statistics",
-				PropertySelector:  "data-read-numeric",
-				PropertiesAsLabel: hostportStatsLabels,
-			}},
-		},
-		"hostport_data_written": {
-			Description: "Data Written",
-			Sources: []MetricSource{{
-				Path:              "host-port-statistics",
-				ObjectSelector:    "host-port-statistics",
-				PropertySelector:  "data-written-numeric",
-				PropertiesAsLabel: hostportStatsLabels,
-			}},
-		},
-		"hostport_avg_resp_time_read": {
-			Description: "Read Response Time",
-			Sources: []MetricSource{{
-				Path:              "host-port-statistics",
-				ObjectSelector:    "host-port-statistics",
-				PropertySelector:  "avg-read-rsp-time",
-				PropertiesAsLabel: hostportStatsLabels,
-			}},
-		},
-		"hostport_avg_resp_time_write": {
-			Description: "Write Response Time",
-			Sources: []MetricSource{{
-				Path:              "host-port-statistics",
-				ObjectSelector:    "host-port-statistics",
-				PropertySelector:  "avg-write-rsp-time",
-				PropertiesAsLabel: hostportStatsLabels,
-			}},
-		},
-		"hostport_avg_resp_time": {
-			Description: "I/O Response Time",
-			Sources: []MetricSource{{
-				Path:              "host-port-statistics",
-				ObjectSelector:    "host-port-statistics",
-				PropertySelector:  "avg-rsp-time",
-				PropertiesAsLabel: hostportStatsLabels,
-			}},
-		},
-		"hostport_queue_depth": {
-			Description: "Queue Depth",
-			Sources: []MetricSource{{
-				Path:              "host-port-statistics",
-				ObjectSelector:    "host-port-statistics",
-				PropertySelector:  "queue-depth",
-				PropertiesAsLabel: hostportStatsLabels,
-			}},
-		},
-		"hostport_reads": {
-			Description: "Reads",
-			Sources: []MetricSource{{
-				Path:              "host-port-statistics",
-				ObjectSelector:    "host-port-statistics",
-				PropertySelector:  "number-of-reads",
-				PropertiesAsLabel: hostportStatsLabels,
-			}},
-		},
-		"hostport_writes": {
-			Description: "Writes",
-			Sources: []MetricSource{{
-				Path:              "host-port-statistics",
-				ObjectSelector:    "host-port-statistics",
-				PropertySelector:  "number-of-writes",
-				PropertiesAsLabel: hostportStatsLabels,
-			}},
-		},
-		"disk_temperature": {
-			Description: "Temperature",
-			Sources: []MetricSource{{
-				Path:              "disks",
-				ObjectSelector:    "drive",
-				PropertySelector:  "temperature-numeric",
-				PropertiesAsLabel: diskLabels,
-			}},
-		},
-		"disk_iops": {
-			Description: "IOPS",
-			Sources: []MetricSource{{
-				Path:              "disk-statistics",
-				ObjectSelector:    "disk-statistics",
-				PropertySelector:  "iops",
-				PropertiesAsLabel: diskLabels,
-			}},
-		},
-		"disk_power_on_hours": {
-			Description: "Power on hours",
-			Sources: []MetricSource{{
-				Path:              "disk-statistics",
-				ObjectSelector:    "disk-statistics",
-				PropertySelector:  "power-on-hours",
-				PropertiesAsLabel: diskLabels,
-			}},
-		},
-		"disk_bps": {
-			Description: "Bytes per second",
-			Sources: []MetricSource{{
-				Path:              "disks",
-				ObjectSelector:    "disk-statistics",
-				PropertySelector:  "bytes-per-second-numeric",
-				PropertiesAsLabel: diskLabels,
-			}},
-		},
-		"disk_avg_resp_time": {
-			Description: "Average I/O Response Time",
-			Sources: []MetricSource{{
-				Path:              "disks",
-				ObjectSelector:    "drive",
-				PropertySelector:  "avg-rsp-time",
-				PropertiesAsLabel: diskLabels,
-			}},
-		},
-		"disk_ssd_life_left": {
-			Description: "SSD Life Remaining",
-			Sources: []MetricSource{{
-				Path:              "disks",
-				ObjectSelector:    "drive",
-				PropertySelector:  "ssd-life-left-numeric",
-				PropertiesAsLabel: diskLabels,
-			}},
-		},
-		"disk_health": {
-			Description: "Health",
-			Sources: []MetricSource{{
-				Path:              "disks",
-				ObjectSelector:    "drive",
-				PropertySelector:  "health-numeric",
-				PropertiesAsLabel: diskLabels,
-			}},
-		},
-		"disk_errors": {
-			Description: "Errors",
-			Sources: []MetricSource{
-				{
-					Path:              "disk-statistics",
-					ObjectSelector:    "disk-statistics",
-					PropertySelector:  "smart-count-1",
-					PropertiesAsLabel: diskLabels,
-					Labels:            map[string]interface{}{"type": "smart", "port": 1},
-				},
-				{
-					Path:              "disk-statistics",
-					ObjectSelector:    "disk-statistics",
-					PropertySelector:  "smart-count-2",
-					PropertiesAsLabel: diskLabels,
-					Labels:            map[string]interface{}{"type": "smart", "port": 2},
-				},
-				{
-					Path:              "disk-statistics",
-					ObjectSelector:    "disk-statistics",
-					PropertySelector:  "io-timeout-count-1",
-					PropertiesAsLabel: diskLabels,
-					Labels:            map[string]interface{}{"type": "io-timeout", "port": 1},
-				},
-				{
-					Path:              "disk-statistics",
-					ObjectSelector:    "disk-statistics",
-					PropertySelector:  "io-timeout-count-2",
-					PropertiesAsLabel: diskLabels,
-					Labels:            map[string]interface{}{"type": "io-timeout", "port": 2},
-				},
-				{
-					Path:              "disk-statistics",
-					ObjectSelector:    "disk-statistics",
-					PropertySelector:  "no-response-count-1",
-					PropertiesAsLabel: diskLabels,
-					Labels:            map[string]interface{}{"type": "no-response", "port": 1},
-				},
-				{
-					Path:              "disk-statistics",
-					ObjectSelector:    "disk-statistics",
-					PropertySelector:  "no-response-count-2",
-					PropertiesAsLabel: diskLabels,
-					Labels:            map[string]interface{}{"type": "no-response", "port": 2},
-				},
-				{
-					Path:              "disk-statistics",
-					ObjectSelector:    "disk-statistics",
-					PropertySelector:  "spinup-retry-count-1",
-					PropertiesAsLabel: diskLabels,
-					Labels:            map[string]interface{}{"type": "spinup-retry", "port": 1},
-				},
-				{
-					Path:              "disk-statistics",
-					ObjectSelector:    "disk-statistics",
-					PropertySelector:  "spinup-retry-count-2",
-					PropertiesAsLabel: diskLabels,
-					Labels:            map[string]interface{}{"type": "spinup-retry", "port": 2},
-				},
-				{
-					Path:              "disk-statistics",
-					ObjectSelector:    "disk-statistics",
-					PropertySelector:  "number-of-media-errors-1",
-					PropertiesAsLabel: diskLabels,
-					Labels:            map[string]interface{}{"type": "media-errors", "port": 1},
-				},
-				{
-					Path:              "disk-statistics",
-					ObjectSelector:    "disk-statistics",
-					PropertySelector:  "number-of-media-errors-2",
-					PropertiesAsLabel: diskLabels,
-					Labels:            map[string]interface{}{"type": "media-errors", "port": 2},
-				},
-				{
-					Path:              "disk-statistics",
-					ObjectSelector:    "disk-statistics",
-					PropertySelector:  "number-of-nonmedia-errors-1",
-					PropertiesAsLabel: diskLabels,
-					Labels:            map[string]interface{}{"type": "nonmedia-errors", "port": 1},
-				},
-				{
-					Path:              "disk-statistics",
-					ObjectSelector:    "disk-statistics",
-					PropertySelector:  "number-of-nonmedia-errors-2",
-					PropertiesAsLabel: diskLabels,
-					Labels:            map[string]interface{}{"type": "nonmedia-errors", "port": 2},
-				},
-				{
-					Path:              "disk-statistics",
-					ObjectSelector:    "disk-statistics",
-					PropertySelector:  "number-of-block-reassigns-1",
-					PropertiesAsLabel: diskLabels,
-					Labels:            map[string]interface{}{"type": "block-reassigns", "port": 1},
-				},
-				{
-					Path:              "disk-statistics",
-					ObjectSelector:    "disk-statistics",
-					PropertySelector:  "number-of-block-reassigns-2",
-					PropertiesAsLabel: diskLabels,
-					Labels:            map[string]interface{}{"type": "block-reassigns", "port": 2},
-				},
-				{
-					Path:              "disk-statistics",
-					ObjectSelector:    "disk-statistics",
-					PropertySelector:  "number-of-bad-blocks-1",
-					PropertiesAsLabel: diskLabels,
-					Labels:            map[string]interface{}{"type": "bad-blocks", "port": 1},
-				},
-				{
-					Path:              "disk-statistics",
-					ObjectSelector:    "disk-statistics",
-					PropertySelector:  "number-of-bad-blocks-2",
-					PropertiesAsLabel: diskLabels,
-					Labels:            map[string]interface{}{"type": "bad-blocks", "port": 2},
-				},
-			},
-		},
-		"volume_health": {
-			Description: "Health",
-			Sources: []MetricSource{{
-				Path:              "volumes",
-				ObjectSelector:    "volume",
-				PropertySelector:  "health-numeric",
-				PropertiesAsLabel: volumeLabels,
-			}},
-		},
-		"volume_iops": {
-			Description: "IOPS",
-			Sources: []MetricSource{{
-				Path:              "volume-statistics",
-				ObjectSelector:    "volume-statistics",
-				PropertySelector:  "iops",
-				PropertiesAsLabel: volumeLabels,
-			}},
-		},
-		"volume_bps": {
-			Description: "Bytes per second",
-			Sources: []MetricSource{{
-				Path:              "volume-statistics",
-				ObjectSelector:    "volume-statistics",
-				PropertySelector:  "bytes-per-second-numeric",
-				PropertiesAsLabel: volumeLabels,
-			}},
-		},
-		"volume_reads": {
-			Description: "Reads",
-			Sources: []MetricSource{{
-				Path:              "volume-statistics",
-				ObjectSelector:    "volume-statistics",
-				PropertySelector:  "number-of-reads",
-				PropertiesAsLabel: volumeLabels,
-			}},
-		},
-		"volume_writes": {
-			Description: "Writes",
-			Sources: []MetricSource{{
-				Path:              "volume-statistics",
-				ObjectSelector:    "volume-statistics",
-				PropertySelector:  "number-of-writes",
-				PropertiesAsLabel: volumeLabels,
-			}},
-		},
-		"volume_data_read": {
-			Description: "Data Read",
-			Sources: []MetricSource{{
-				Path:              "volume-statistics",
-				ObjectSelector:    "volume-statistics",
-				PropertySelector:  "data-read-numeric",
-				PropertiesAsLabel: volumeLabels,
-			}},
-		},
-		"volume_data_written": {
-			Description: "Data Written",
-			Sources: []MetricSource{{
-				Path:              "volume-statistics",
-				ObjectSelector:    "volume-statistics",
-				PropertySelector:  "data-written-numeric",
-				PropertiesAsLabel: volumeLabels,
-			}},
-		},
-		"volume_shared_pages": {
-			Description: "Shared Pages",
-			Sources: []MetricSource{{
-				Path:              "volume-statistics",
-				ObjectSelector:    "volume-statistics",
-				PropertySelector:  "shared-pages",
-				PropertiesAsLabel: volumeLabels,
-			}},
-		},
-		"volume_read_hits": {
-			Description: "Read-Cache Hits",
-			Sources: []MetricSource{{
-				Path:              "volume-statistics",
-				ObjectSelector:    "volume-statistics",
-				PropertySelector:  "read-cache-hits",
-				PropertiesAsLabel: volumeLabels,
-			}},
-		},
-		"volume_read_misses": {
-			Description: "Read-Cache Misses",
-			Sources: []MetricSource{{
-				Path:              "volume-statistics",
-				ObjectSelector:    "volume-statistics",
-				PropertySelector:  "read-cache-misses",
-				PropertiesAsLabel: volumeLabels,
-			}},
-		},
-		"volume_write_hits": {
-			Description: "Read-Cache Hits",
-			Sources: []MetricSource{{
-				Path:              "volume-statistics",
-				ObjectSelector:    "volume-statistics",
-				PropertySelector:  "write-cache-hits",
-				PropertiesAsLabel: volumeLabels,
-			}},
-		},
-		"volume_write_misses": {
-			Description: "Read-Cache Misses",
-			Sources: []MetricSource{{
-				Path:              "volume-statistics",
-				ObjectSelector:    "volume-statistics",
-				PropertySelector:  "write-cache-misses",
-				PropertiesAsLabel: volumeLabels,
-			}},
-		},
-		"volume_small_destage": {
-			Description: "Small Destages",
-			Sources: []MetricSource{{
-				Path:              "volume-statistics",
-				ObjectSelector:    "volume-statistics",
-				PropertySelector:  "small-destages",
-				PropertiesAsLabel: volumeLabels,
-			}},
-		},
-		"volume_full_stripe_write_destages": {
-			Description: "Full Stripe Write Destages",
-			Sources: []MetricSource{{
-				Path:              "volume-statistics",
-				ObjectSelector:    "volume-statistics",
-				PropertySelector:  "full-stripe-write-destages",
-				PropertiesAsLabel: volumeLabels,
-			}},
-		},
-		"volume_read_ahead_ops": {
-			Description: "Read-Ahead Operations",
-			Sources: []MetricSource{{
-				Path:              "volume-statistics",
-				ObjectSelector:    "volume-statistics",
-				PropertySelector:  "read-ahead-operations",
-				PropertiesAsLabel: volumeLabels,
-			}},
-		},
-		"volume_write_cache_space": {
-			Description: "Write Cache Space",
-			Sources: []MetricSource{{
-				Path:              "volume-statistics",
-				ObjectSelector:    "volume-statistics",
-				PropertySelector:  "write-cache-space",
-				PropertiesAsLabel: volumeLabels,
-			}},
-		},
-		"volume_write_cache_percent": {
-			Description: "Write Cache Percentage",
-			Sources: []MetricSource{{
-				Path:              "volume-statistics",
-				ObjectSelector:    "volume-statistics",
-				PropertySelector:  "write-cache-percent",
-				PropertiesAsLabel: volumeLabels,
-			}},
-		},
-		"volume_size": {
-			Description: "Size",
-			Sources: []MetricSource{{
-				Path:              "volumes",
-				ObjectSelector:    "volume",
-				PropertySelector:  "size-numeric",
-				PropertiesAsLabel: volumeLabels,
-			}},
-		},
-		"volume_total_size": {
-			Description: "Total Size",
-			Sources: []MetricSource{{
-				Path:              "volumes",
-				ObjectSelector:    "volume",
-				PropertySelector:  "total-size-numeric",
-				PropertiesAsLabel: volumeLabels,
-			}},
-		},
-		"volume_allocated_size": {
-			Description: "Total Size",
-			Sources: []MetricSource{{
-				Path:              "volumes",
-				ObjectSelector:    "volume",
-				PropertySelector:  "allocated-size-numeric",
-				PropertiesAsLabel: volumeLabels,
-			}},
-		},
-		"volume_blocks": {
-			Description: "Blocks",
-			Sources: []MetricSource{{
-				Path:              "volumes",
-				ObjectSelector:    "volume",
-				PropertySelector:  "blocks",
-				PropertiesAsLabel: volumeLabels,
-			}},
-		},
-		"volume_tier_distribution": {
-			Description: "Volume tier distribution",
-			Sources: []MetricSource{
-				{
-					Path:              "volume-statistics",
-					ObjectSelector:    "volume-statistics",
-					PropertySelector:  "percent-tier-ssd",
-					PropertiesAsLabel: volumeLabels,
-					Labels:            map[string]interface{}{"tier": "Performance"},
-				},
-				{
-					Path:              "volume-statistics",
-					ObjectSelector:    "volume-statistics",
-					PropertySelector:  "percent-tier-sas",
-					PropertiesAsLabel: volumeLabels,
-					Labels:            map[string]interface{}{"tier": "Standard"},
-				},
-				{
-					Path:              "volume-statistics",
-					ObjectSelector:    "volume-statistics",
-					PropertySelector:  "percent-tier-sata",
-					PropertiesAsLabel: volumeLabels,
-					Labels:            map[string]interface{}{"tier": "Archive"},
-				},
-				{
-					Path:              "volume-statistics",
-					ObjectSelector:    "volume-statistics",
-					PropertySelector:  "percent-allocated-rfc",
-					PropertiesAsLabel: volumeLabels,
-					Labels:            map[string]interface{}{"tier": "RFC"},
-				},
-			},
-		},
-		"pool_data_read": {
-			Description: "Data Read",
-			Sources: []MetricSource{{
-				Path:              "pool-statistics",
-				ObjectSelector:    "pool-statistics",
-				PropertySelector:  "data-read-numeric",
-				PropertiesAsLabel: poolStatsLabels,
-			}},
-		},
-		"pool_data_written": {
-			Description: "Data Written",
-			Sources: []MetricSource{{
-				Path:              "pool-statistics",
-				ObjectSelector:    "pool-statistics",
-				PropertySelector:  "data-written-numeric",
-				PropertiesAsLabel: poolStatsLabels,
-			}},
-		},
-		"pool_avg_resp_time": {
-			Description: "I/O Response Time",
-			Sources: []MetricSource{{
-				Path:              "pool-statistics",
-				ObjectSelector:    "pool-statistics",
-				PropertySelector:  "avg-rsp-time",
-				PropertiesAsLabel: poolStatsLabels,
-			}},
-		},
-		"pool_avg_resp_time_read": {
-			Description: "Read Response Time",
-			Sources: []MetricSource{{
-				Path:              "pool-statistics",
-				ObjectSelector:    "pool-statistics",
-				PropertySelector:  "avg-read-rsp-time",
-				PropertiesAsLabel: poolStatsLabels,
-			}},
-		},
-		"pool_total_size": {
-			Description: "Total Size",
-			Sources: []MetricSource{{
-				Path:              "pools",
-				ObjectSelector:    "pools",
-				PropertySelector:  "total-size-numeric",
-				PropertiesAsLabel: poolLabels,
-			}},
-		},
-		"pool_available_size": {
-			Description: "Available Size",
-			Sources: []MetricSource{{
-				Path:              "pools",
-				ObjectSelector:    "pools",
-				PropertySelector:  "total-avail-numeric",
-				PropertiesAsLabel: poolLabels,
-			}},
-		},
-		"pool_snapshot_size": {
-			Description: "Snapshot Size",
-			Sources: []MetricSource{{
-				Path:              "pools",
-				ObjectSelector:    "pools",
-				PropertySelector:  "snap-size-numeric",
-				PropertiesAsLabel: poolLabels,
-			}},
-		},
-		"pool_allocated_pages": {
-			Description: "Allocated Pages",
-			Sources: []MetricSource{{
-				Path:              "pools",
-				ObjectSelector:    "pools",
-				PropertySelector:  "allocated-pages",
-				PropertiesAsLabel: poolLabels,
-			}},
-		},
-		"pool_available_pages": {
-			Description: "Available Pages",
-			Sources: []MetricSource{{
-				Path:              "pools",
-				ObjectSelector:    "pools",
-				PropertySelector:  "available-pages",
-				PropertiesAsLabel: poolLabels,
-			}},
-		},
-		"pool_metadata_volume_size": {
-			Description: "Metadata Volume Size",
-			Sources: []MetricSource{{
-				Path:              "pools",
-				ObjectSelector:    "pools",
-				PropertySelector:  "metadata-vol-size-numeric",
-				PropertiesAsLabel: poolLabels,
-			}},
-		},
-		"pool_total_rfc_size": {
-			Description: "Total RFC Size",
-			Sources: []MetricSource{{
-				Path:              "pools",
-				ObjectSelector:    "pools",
-				PropertySelector:  "total-rfc-size-numeric",
-				PropertiesAsLabel: poolLabels,
-			}},
-		},
-		"pool_available_rfc_size": {
-			Description: "Available RFC Size",
-			Sources: []MetricSource{{
-				Path:              "pools",
-				ObjectSelector:    "pools",
-				PropertySelector:  "available-rfc-size-numeric",
-				PropertiesAsLabel: poolLabels,
-			}},
-		},
-		"pool_reserved_size": {
-			Description: "Reserved Size",
-			Sources: []MetricSource{{
-				Path:              "pools",
-				ObjectSelector:    "pools",
-				PropertySelector:  "reserved-size-numeric",
-				PropertiesAsLabel: poolLabels,
-			}},
-		},
-		"pool_unallocated_reserved_size": {
-			Description: "Unallocated Reserved Size",
-			Sources: []MetricSource{{
-				Path:              "pools",
-				ObjectSelector:    "pools",
-				PropertySelector:  "reserved-unalloc-size-numeric",
-				PropertiesAsLabel: poolLabels,
-			}},
-		},
-		"tier_reads": {
-			Description: "Reads",
-			Sources: []MetricSource{{
-				Path:              "pool-statistics",
-				ObjectSelector:    "tier-statistics",
-				PropertySelector:  "number-of-reads",
-				PropertiesAsLabel: tierLabels,
-			}},
-		},
-		"tier_writes": {
-			Description: "Writes",
-			Sources: []MetricSource{{
-				Path:              "pool-statistics",
-				ObjectSelector:    "tier-statistics",
-				PropertySelector:  "number-of-writes",
-				PropertiesAsLabel: tierLabels,
-			}},
-		},
-		"tier_data_read": {
-			Description: "Data Read",
-			Sources: []MetricSource{{
-				Path:              "pool-statistics",
-				ObjectSelector:    "tier-statistics",
-				PropertySelector:  "data-read-numeric",
-				PropertiesAsLabel: tierLabels,
-			}},
-		},
-		"tier_data_written": {
-			Description: "Data Written",
-			Sources: []MetricSource{{
-				Path:              "pool-statistics",
-				ObjectSelector:    "tier-statistics",
-				PropertySelector:  "data-written-numeric",
-				PropertiesAsLabel: tierLabels,
-			}},
-		},
-		"tier_avg_resp_time": {
-			Description: "I/O Response Time",
-			Sources: []MetricSource{{
-				Path:              "pool-statistics",
-				ObjectSelector:    "tier-statistics",
-				PropertySelector:  "avg-rsp-time",
-				PropertiesAsLabel: tierLabels,
-			}},
-		},
-		"tier_avg_resp_time_read": {
-			Description: "Read Response Time",
-			Sources: []MetricSource{{
-				Path:              "pool-statistics",
-				ObjectSelector:    "tier-statistics",
-				PropertySelector:  "avg-read-rsp-time",
-				PropertiesAsLabel: tierLabels,
-			}},
-		},
-		"tier_avg_resp_time_write": {
-			Description: "Write Response Time",
-			Sources: []MetricSource{{
-				Path:              "pool-statistics",
-				ObjectSelector:    "tier-statistics",
-				PropertySelector:  "avg-write-rsp-time",
-				PropertiesAsLabel: tierLabels,
-			}},
-		},
-		"enclosure_power": {
-			Description: "Power consumption in watts",
-			Sources: []MetricSource{{
-				Path:              "enclosures",
-				ObjectSelector:    "enclosures",
-				PropertySelector:  "enclosure-power",
-				PropertiesAsLabel: map[string]string{"enclosure-id": "id", "enclosure-wwn": "wwn"},
-			}},
-		},
-		"controller_cpu": {
-			Description: "CPU Load",
-			Sources: []MetricSource{{
-				Path:              "controller-statistics",
-				ObjectSelector:    "controller-statistics",
-				PropertySelector:  "cpu-load",
-				PropertiesAsLabel: controllerLabels,
-			}},
-		},
-		"controller_iops": {
-			Description: "IOPS",
-			Sources: []MetricSource{{
-				Path:              "controller-statistics",
-				ObjectSelector:    "controller-statistics",
-				PropertySelector:  "iops",
-				PropertiesAsLabel: controllerLabels,
-			}},
-		},
-		"controller_bps": {
-			Description: "Bytes per second",
-			Sources: []MetricSource{{
-				Path:              "controller-statistics",
-				ObjectSelector:    "controller-statistics",
-				PropertySelector:  "bytes-per-second-numeric",
-				PropertiesAsLabel: controllerLabels,
-			}},
-		},
-		"controller_read_hits": {
-			Description: "Read-Cache Hits",
-			Sources: []MetricSource{{
-				Path:              "controller-statistics",
-				ObjectSelector:    "controller-statistics",
-				PropertySelector:  "read-cache-hits",
-				PropertiesAsLabel: controllerLabels,
-			}},
-		},
-		"controller_read_misses": {
-			Description: "Read-Cache Misses",
-			Sources: []MetricSource{{
-				Path:              "controller-statistics",
-				ObjectSelector:    "controller-statistics",
-				PropertySelector:  "read-cache-misses",
-				PropertiesAsLabel: controllerLabels,
-			}},
-		},
-		"controller_write_hits": {
-			Description: "Write-Cache Hits",
-			Sources: []MetricSource{{
-				Path:              "controller-statistics",
-				ObjectSelector:    "controller-statistics",
-				PropertySelector:  "write-cache-hits",
-				PropertiesAsLabel: controllerLabels,
-			}},
-		},
-		"controller_write_misses": {
-			Description: "Write-Cache Misses",
-			Sources: []MetricSource{{
-				Path:              "controller-statistics",
-				ObjectSelector:    "controller-statistics",
-				PropertySelector:  "write-cache-misses",
-				PropertiesAsLabel: controllerLabels,
-			}},
-		},
-		"psu_health": {
-			Description: "Power-supply unit health",
-			Sources: []MetricSource{{
-				Path:              "enclosure",
-				ObjectSelector:    "power-supplies",
-				PropertySelector:  "health-numeric",
-				PropertiesAsLabel: psuLabels,
-			}},
-		},
-		"psu_status": {
-			Description: "Power-supply unit status",
-			Sources: []MetricSource{{
-				Path:              "enclosure",
-				ObjectSelector:    "power-supplies",
-				PropertySelector:  "status-numeric",
-				PropertiesAsLabel: psuLabels,
-			}},
-		},
-		"system_health": {
-			Description: "System health",
-			Sources: []MetricSource{{
-				Path:              "system",
-				ObjectSelector:    "system-information",
-				PropertySelector:  "health-numeric",
-				PropertiesAsLabel: map[string]string{},
-			}},
-		},
+// LoadMetricDefinitions returns the default metric definitions extended by files.
+// A metric defined in a file replaces any earlier definition with the same name.
+func LoadMetricDefinitions(files []string) (map[string]MetricDefinition, error) {
+	metrics := make(map[string]MetricDefinition)
+	for name, metric := range getMetrics() {
+		metrics[name] = metric
+	}
+
+	for _, filename := range files {
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read metrics file: %w", err)
+		}
+		fileMetrics, err := ParseMetricDefinitions(content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load metrics file %s: %w", filename, err)
+		}
+		for name, metric := range fileMetrics {
+			metrics[name] = metric
+		}
+	}
+	return metrics, nil
+}
+
+// LabelSchema returns the sorted label names of every sample of the metric.
+// Without declared label_names it is the union of the labels of all sources.
+func (m MetricDefinition) LabelSchema() []string {
+	if len(m.LabelNames) > 0 {
+		return slices.Sorted(slices.Values(m.LabelNames))
+	}
+	labels := make(map[string]bool)
+	for _, source := range m.Sources {
+		for _, label := range source.PropertiesAsLabel {
+			labels[label] = true
+		}
+		for _, parent := range source.ParentLabels {
+			for _, label := range parent.PropertiesAsLabel {
+				labels[label] = true
+			}
+		}
+		for label := range source.Labels {
+			labels[label] = true
+		}
+	}
+	return slices.Sorted(maps.Keys(labels))
+}
+
+// metricSchemas returns the description and label schema of every metric.
+// A registry keeps both for the lifetime of the process once a metric is
+// registered, so a change requires a new registry.
+func metricSchemas(metrics map[string]MetricDefinition) map[string]string {
+	schemas := make(map[string]string, len(metrics))
+	for name, metric := range metrics {
+		schemas[name] = metric.Description + "\xff" + strings.Join(metric.LabelSchema(), ",")
+	}
+	return schemas
+}
+
+// validateMetricDefinition checks a single metric definition for errors
+func validateMetricDefinition(name string, metric MetricDefinition) error {
+	if !metricNameRE.MatchString(name) {
+		return fmt.Errorf("metric %q: invalid metric name", name)
+	}
+	if reservedMetricNames[name] {
+		return fmt.Errorf("metric %q: name is reserved by the exporter", name)
+	}
+	if metric.Description == "" {
+		return fmt.Errorf("metric %q: description is required", name)
+	}
+	if len(metric.Sources) == 0 {
+		return fmt.Errorf("metric %q: at least one source is required", name)
+	}
+	declared := make(map[string]bool, len(metric.LabelNames))
+	for _, label := range metric.LabelNames {
+		if !labelNameRE.MatchString(label) {
+			return fmt.Errorf("metric %q: invalid label name %q", name, label)
+		}
+		if declared[label] {
+			return fmt.Errorf("metric %q: duplicate label name %q", name, label)
+		}
+		declared[label] = true
+	}
+	for i, source := range metric.Sources {
+		switch {
+		case source.Path == "":
+			return fmt.Errorf("metric %q source #%d: path is required", name, i+1)
+		case strings.Contains(source.Path, "/"):
+			return fmt.Errorf("metric %q source #%d: path must not contain '/'", name, i+1)
+		case source.ObjectSelector == "" && source.ObjectBasetype == "":
+			return fmt.Errorf("metric %q source #%d: object_selector or object_basetype is required", name, i+1)
+		case source.PropertySelector == "":
+			return fmt.Errorf("metric %q source #%d: property_selector is required", name, i+1)
+		}
+		for property, label := range source.PropertiesAsLabel {
+			if !labelNameRE.MatchString(label) {
+				return fmt.Errorf("metric %q source #%d: invalid label name %q for property %q", name, i+1, label, property)
+			}
+			if len(declared) > 0 && !declared[label] {
+				return fmt.Errorf("metric %q source #%d: label %q is not in label_names", name, i+1, label)
+			}
+		}
+		for j, parent := range source.ParentLabels {
+			switch {
+			case parent.ObjectSelector == "" && parent.ObjectBasetype == "":
+				return fmt.Errorf("metric %q source #%d parent #%d: object_selector or object_basetype is required", name, i+1, j+1)
+			case len(parent.PropertiesAsLabel) == 0:
+				return fmt.Errorf("metric %q source #%d parent #%d: properties_as_label is required", name, i+1, j+1)
+			}
+			for property, label := range parent.PropertiesAsLabel {
+				if !labelNameRE.MatchString(label) {
+					return fmt.Errorf("metric %q source #%d parent #%d: invalid label name %q for property %q", name, i+1, j+1, label, property)
+				}
+				if len(declared) > 0 && !declared[label] {
+					return fmt.Errorf("metric %q source #%d parent #%d: label %q is not in label_names", name, i+1, j+1, label)
+				}
+			}
+		}
+		for label := range source.Labels {
+			if !labelNameRE.MatchString(label) {
+				return fmt.Errorf("metric %q source #%d: invalid label name %q", name, i+1, label)
+			}
+			if len(declared) > 0 && !declared[label] {
+				return fmt.Errorf("metric %q source #%d: label %q is not in label_names", name, i+1, label)
+			}
+		}
 	}
+	return nil
 }
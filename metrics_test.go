@@ -1,7 +1,13 @@
 package main
 
 import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
 	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 func TestGetMetrics(t *testing.T) {
@@ -290,8 +296,8 @@ func TestMetricSourceConsistency(t *testing.T) {
 				t.Errorf("Metric %s source %d has empty path", name, i)
 			}
 
-			// Check that object selector is not empty
-			if source.ObjectSelector == "" {
+			// Check that objects are selected by name or basetype
+			if source.ObjectSelector == "" && source.ObjectBasetype == "" {
 				t.Errorf("Metric %s source %d has empty object selector", name, i)
 			}
 
@@ -314,3 +320,347 @@ func TestMetricCount(t *testing.T) {
 		t.Errorf("Expected at least 70 metrics, got %d", len(metrics))
 	}
 }
+
+func TestLoadMetricDefinitions(t *testing.T) {
+	// Definitions hold anchors of any shape
+	extra := writeTestFile(t, "extra.yml", `
+definitions:
+  controller_labels: &controller_labels
+    durable-id: controller
+  overridden: &overridden Overridden system health
+metrics:
+  controller_forwarded_cmds:
+    description: Forwarded Commands
+    sources:
+      - path: controller-statistics
+        object_selector: controller-statistics
+        property_selector: num-forwarded-cmds
+        properties_as_label: *controller_labels
+  system_health:
+    description: *overridden
+    sources:
+      - path: system
+        object_selector: system-information
+        property_selector: health-numeric
+`)
+	override := writeTestFile(t, "override.json", `{
+  "metrics": {
+    "controller_forwarded_cmds": {
+      "description": "Forwarded Commands (JSON)",
+      "sources": [{
+        "path": "controller-statistics",
+        "object_selector": "controller-statistics",
+        "property_selector": "num-forwarded-cmds",
+        "labels": {"source": "json", "port": 1}
+      }]
+    }
+  }
+}`)
+
+	metrics, err := LoadMetricDefinitions([]string{extra, override})
+	if err != nil {
+		t.Fatalf("LoadMetricDefinitions failed: %v", err)
+	}
+
+	if len(metrics) != len(getMetrics())+1 {
+		t.Errorf("Expected %d metrics, got %d", len(getMetrics())+1, len(metrics))
+	}
+	if metrics["system_health"].Description != "Overridden system health" {
+		t.Errorf("system_health was not overridden: %q", metrics["system_health"].Description)
+	}
+
+	forwarded := metrics["controller_forwarded_cmds"]
+	if forwarded.Description != "Forwarded Commands (JSON)" {
+		t.Errorf("Later files should override earlier ones, got %q", forwarded.Description)
+	}
+	if forwarded.Sources[0].Labels["port"] != 1 {
+		t.Errorf("Expected numeric static label, got %v", forwarded.Sources[0].Labels["port"])
+	}
+
+	// The defaults must not be modified by loading extra files
+	if getMetrics()["system_health"].Description != "System health" {
+		t.Error("Default metric definitions were modified")
+	}
+}
+
+func TestLoadMetricDefinitionsErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		content       string
+		expectedError string
+	}{
+		{
+			name:          "invalid yaml",
+			content:       "metrics: [",
+			expectedError: "failed to load metrics file",
+		},
+		{
+			name: "unknown field",
+			content: `
+metrics:
+  test:
+    description: Test
+    sources:
+      - path: system
+        selector: system
+`,
+			expectedError: "field selector not found",
+		},
+		{
+			name: "missing description",
+			content: `
+metrics:
+  test:
+    sources:
+      - path: system
+        object_selector: system
+        property_selector: health
+`,
+			expectedError: `metric "test": description is required`,
+		},
+		{
+			name: "no sources",
+			content: `
+metrics:
+  test:
+    description: Test
+`,
+			expectedError: "at least one source is required",
+		},
+		{
+			name: "missing object selector",
+			content: `
+metrics:
+  test:
+    description: Test
+    sources:
+      - path: system
+        property_selector: health
+`,
+			expectedError: "object_selector or object_basetype is required",
+		},
+		{
+			name: "missing property selector",
+			content: `
+metrics:
+  test:
+    description: Test
+    sources:
+      - path: system
+        object_selector: system
+`,
+			expectedError: "property_selector is required",
+		},
+		{
+			name: "invalid metric name",
+			content: `
+metrics:
+  test-metric:
+    description: Test
+    sources:
+      - path: system
+        object_selector: system
+        property_selector: health
+`,
+			expectedError: "invalid metric name",
+		},
+		{
+			name: "invalid label name",
+			content: `
+metrics:
+  test:
+    description: Test
+    sources:
+      - path: system
+        object_selector: system
+        property_selector: health
+        properties_as_label:
+          durable-id: durable-id
+`,
+			expectedError: `invalid label name "durable-id"`,
+		},
+		{
+			name: "reserved metric name",
+			content: `
+metrics:
+  version:
+    description: Test
+    sources:
+      - path: system
+        object_selector: system
+        property_selector: health
+`,
+			expectedError: "name is reserved",
+		},
+		{
+			name: "label outside declared schema",
+			content: `
+metrics:
+  test:
+    description: Test
+    label_names: [name]
+    sources:
+      - path: system
+        object_selector: system
+        property_selector: health
+        properties_as_label:
+          product-id: product
+`,
+			expectedError: `label "product" is not in label_names`,
+		},
+		{
+			name: "parent without selector",
+			content: `
+metrics:
+  test:
+    description: Test
+    sources:
+      - path: pool-statistics
+        object_selector: tier-statistics
+        property_selector: number-of-reads
+        parent_labels:
+          - properties_as_label:
+              pool: pool
+`,
+			expectedError: "parent #1: object_selector or object_basetype is required",
+		},
+		{
+			name: "parent without labels",
+			content: `
+metrics:
+  test:
+    description: Test
+    sources:
+      - path: pool-statistics
+        object_selector: tier-statistics
+        property_selector: number-of-reads
+        parent_labels:
+          - object_basetype: pool-statistics
+`,
+			expectedError: "parent #1: properties_as_label is required",
+		},
+		{
+			name: "parent label outside declared schema",
+			content: `
+metrics:
+  test:
+    description: Test
+    label_names: [tier]
+    sources:
+      - path: pool-statistics
+        object_selector: tier-statistics
+        property_selector: number-of-reads
+        properties_as_label:
+          tier: tier
+        parent_labels:
+          - object_basetype: pool-statistics
+            properties_as_label:
+              pool: pool
+`,
+			expectedError: `parent #1: label "pool" is not in label_names`,
+		},
+		{
+			name: "filter without predicate",
+			content: `
+metrics:
+  test:
+    description: Test
+    sources:
+      - path: disks
+        object_selector: drive
+        filters:
+          - property: architecture
+        property_selector: temperature-numeric
+`,
+			expectedError: "invalid filter: exactly one of equals, not_equals, regex or exists",
+		},
+		{
+			name: "filter with invalid regex",
+			content: `
+metrics:
+  test:
+    description: Test
+    sources:
+      - path: disks
+        object_selector: drive
+        filters:
+          - property: location
+            regex: "1.("
+        property_selector: temperature-numeric
+`,
+			expectedError: "invalid filter: invalid regex",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadMetricDefinitions([]string{writeTestFile(t, "metrics.yml", tt.content)})
+			if err == nil {
+				t.Fatal("Expected error")
+			}
+			if !strings.Contains(err.Error(), tt.expectedError) {
+				t.Errorf("Expected error containing %q, got %q", tt.expectedError, err)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := LoadMetricDefinitions([]string{"/nonexistent/metrics.yml"}); err == nil {
+			t.Error("Expected error for missing file")
+		}
+	})
+}
+
+func TestReservedMetricNames(t *testing.T) {
+	// Every metric of the exporter itself is reserved
+	collectors := []prometheus.Collector{
+		targetUp, scrapeDuration, scrapeLastSuccess, apiRequestDuration, apiRequestErrors,
+		loginFailures, loginCircuitState, activeController, configReloadSuccess, configReloadSeconds,
+	}
+	fqName := regexp.MustCompile(`fqName: "([^"]+)"`)
+	for _, collector := range collectors {
+		ch := make(chan *prometheus.Desc, 1)
+		collector.Describe(ch)
+		match := fqName.FindStringSubmatch((<-ch).String())
+		if match == nil {
+			t.Fatal("Descriptor without name")
+		}
+		name := strings.TrimPrefix(match[1], prefix)
+		if !reservedMetricNames[name] {
+			t.Errorf("Metric name %q of the exporter is not reserved", name)
+		}
+	}
+
+	for name := range reservedMetricNames {
+		content := fmt.Sprintf(`
+metrics:
+  %s:
+    description: Test
+    sources:
+      - path: system
+        object_selector: system
+        property_selector: health
+`, name)
+		if _, err := ParseMetricDefinitions([]byte(content)); err == nil || !strings.Contains(err.Error(), "name is reserved") {
+			t.Errorf("Expected %q to be rejected as reserved, got %v", name, err)
+		}
+	}
+}
+
+func TestLabelSchema(t *testing.T) {
+	derived := MetricDefinition{
+		Sources: []MetricSource{
+			{PropertiesAsLabel: map[string]string{"name": "name"}, Labels: map[string]interface{}{"tier": "ssd"}},
+			{PropertiesAsLabel: map[string]string{"name": "name", "serial-number": "serial"}},
+			{ParentLabels: []ParentLabels{{ObjectBasetype: "pools", PropertiesAsLabel: map[string]string{"name": "pool"}}}},
+		},
+	}
+	if schema := derived.LabelSchema(); !slices.Equal(schema, []string{"name", "pool", "serial", "tier"}) {
+		t.Errorf("Expected union of source labels, got %v", schema)
+	}
+
+	declared := MetricDefinition{LabelNames: []string{"serial", "name"}}
+	if schema := declared.LabelSchema(); !slices.Equal(schema, []string{"name", "serial"}) {
+		t.Errorf("Expected sorted declared labels, got %v", schema)
+	}
+}
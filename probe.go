@@ -0,0 +1,99 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/model"
+)
+
+const defaultModule = "default"
+
+// probeHandler serves /probe?target=<host>&module=<auth> requests.
+// Every request logs in to the target, collects into a fresh registry
+// and returns the result, so Prometheus owns target lists and intervals.
+// The target may be a configured target name or, if allowAdhoc is set,
+// an arbitrary HTTPS address, which is connected to with tlsConfig.
+// Ad-hoc targets are off by default: logging in sends a hash of the
+// default credentials to whichever host the request names.
+func probeHandler(state *SafeConfig, pool *clientPool, timeout time.Duration, tlsConfig TLSConfig, allowAdhoc bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg, metrics := state.Get()
+
+		targetParam := r.URL.Query().Get("target")
+		if targetParam == "" {
+			http.Error(w, "target parameter is missing", http.StatusBadRequest)
+			return
+		}
+		moduleName := r.URL.Query().Get("module")
+
+		target, ok := cfg.FindTarget(targetParam)
+		if !ok {
+			// Ad-hoc targets are labelled with their normalized address
+			address, err := normalizeAddress(targetParam)
+			if err != nil {
+				http.Error(w, "invalid target: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if target, ok = cfg.FindTarget(address); !ok {
+				if !allowAdhoc {
+					http.Error(w, "target "+strconv.Quote(targetParam)+" is not configured", http.StatusBadRequest)
+					return
+				}
+				// Plain HTTP would expose the login hash to the network
+				if strings.Contains(address, "://") {
+					http.Error(w, "invalid target: ad-hoc targets must use HTTPS", http.StatusBadRequest)
+					return
+				}
+				target = TargetConfig{
+					Name:        address,
+					Host:        address,
+					AuthModule:  defaultModule,
+					Timeout:     model.Duration(timeout),
+					TLSConfig:   tlsConfig,
+					Concurrency: defaultConcurrency,
+					Retry:       DefaultRetryConfig,
+				}
+			}
+		}
+		// Configured targets share their client with the scrape loop
+		clientKey := target.Name
+		if moduleName != "" {
+			target.AuthModule = moduleName
+			target.Login, target.Password, target.PasswordFile = "", "", ""
+			clientKey += "?module=" + moduleName
+		}
+		auth, err := cfg.Credentials(target)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		registry := prometheus.NewRegistry()
+		upGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: prefix + "up",
+			Help: "Whether the last scrape of the MSA array was successful",
+		})
+		durationGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: prefix + "scrape_duration_seconds",
+			Help: "Duration of the last scrape of the MSA array",
+		})
+		registry.MustRegister(upGauge, durationGauge)
+
+		metricStore := NewMetricStoreWithRegisterer(prometheus.WrapRegistererWith(target.Labels, registry))
+		start := time.Now()
+		if err := scrapeTarget(r.Context(), pool, clientKey, target, auth, metricStore, metrics); err != nil {
+			slog.Warn("Probe failed", "target", target.Name, "err", err)
+		} else {
+			upGauge.Set(1)
+		}
+		durationGauge.Set(time.Since(start).Seconds())
+
+		promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// newProbeTestServer returns a mock MSA server accepting probeuser/probepass
+func newProbeTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/login/" + getSHA256("probeuser_probepass"):
+			_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<RESPONSE>
+	<OBJECT name="status">
+		<PROPERTY name="response">probe-session-key</PROPERTY>
+	</OBJECT>
+</RESPONSE>`))
+		case "/api/show/version":
+			_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<RESPONSE>
+	<OBJECT name="controller-a-versions">
+		<PROPERTY name="bundle-version">1.0</PROPERTY>
+		<PROPERTY name="bundle-base-version">1.0</PROPERTY>
+		<PROPERTY name="sc-fw">1.0</PROPERTY>
+		<PROPERTY name="mc-fw">1.0</PROPERTY>
+		<PROPERTY name="pld-rev">1.0</PROPERTY>
+	</OBJECT>
+</RESPONSE>`))
+		case "/api/show/disks":
+			_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<RESPONSE>
+	<OBJECT name="drive">
+		<PROPERTY name="location">1.1</PROPERTY>
+		<PROPERTY name="serial-number">PROBE123</PROPERTY>
+		<PROPERTY name="temperature-numeric">38</PROPERTY>
+	</OBJECT>
+</RESPONSE>`))
+		case "/api/show/system":
+			_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<RESPONSE>
+	<OBJECT name="system-information">
+		<PROPERTY name="health-numeric">0</PROPERTY>
+	</OBJECT>
+</RESPONSE>`))
+		default:
+			if strings.HasPrefix(r.URL.Path, "/api/login/") {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><RESPONSE></RESPONSE>`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestProbeHandler(t *testing.T) {
+	server := newProbeTestServer(t)
+	host := server.URL[8:]
+
+	cfg := &Config{
+		AuthModules: map[string]AuthModule{
+			defaultModule: {Login: "probeuser", Password: "probepass"},
+			"wrong":       {Login: "probeuser", Password: "wrongpass"},
+		},
+		Targets: []TargetConfig{{
+			Name:      "array1",
+			Host:      host,
+			Login:     "probeuser",
+			Password:  "probepass",
+			Timeout:   model.Duration(10 * time.Second),
+			TLSConfig: testTLSConfig,
+			Labels:    map[string]string{"datacenter": "dc1"},
+		}},
+	}
+	handler := probeHandler(&SafeConfig{cfg: cfg, metrics: getMetrics()}, newClientPool(), 10*time.Second, testTLSConfig, true)
+
+	t.Run("missing target", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/probe", nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("invalid target", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/probe?target=ftp://"+host, nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("plain HTTP ad-hoc target", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/probe?target=http://"+host, nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("ad-hoc targets disabled", func(t *testing.T) {
+		handler := probeHandler(&SafeConfig{cfg: cfg, metrics: getMetrics()}, newClientPool(), 10*time.Second, testTLSConfig, false)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/probe?target="+host, nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/probe?target=array1", nil))
+		if !strings.Contains(rr.Body.String(), "msa_up 1") {
+			t.Error("Probe of configured target did not succeed")
+		}
+	})
+
+	t.Run("target URL is normalized", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/probe?target=https://"+host+"/", nil))
+		if !strings.Contains(rr.Body.String(), "msa_up 1") {
+			t.Error("Probe of target URL did not succeed")
+		}
+	})
+
+	t.Run("unknown module", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/probe?target="+host+"&module=missing", nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("successful probe", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/probe?target="+host, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		body := rr.Body.String()
+		for _, expected := range []string{
+			"msa_up 1",
+			`msa_disk_temperature{location="1.1",serial="PROBE123"} 38`,
+			"msa_system_health 0",
+			"msa_scrape_duration_seconds",
+		} {
+			if !strings.Contains(body, expected) {
+				t.Errorf("Probe output does not contain %q", expected)
+			}
+		}
+	})
+
+	t.Run("repeated probes use fresh registries", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest("GET", "/probe?target="+host+"&module="+defaultModule, nil))
+			if !strings.Contains(rr.Body.String(), "msa_up 1") {
+				t.Errorf("Probe %d did not succeed", i)
+			}
+		}
+	})
+
+	t.Run("configured target", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/probe?target=array1", nil))
+		body := rr.Body.String()
+		if !strings.Contains(body, "msa_up 1") {
+			t.Error("Probe of configured target did not succeed")
+		}
+		if !strings.Contains(body, `msa_system_health{datacenter="dc1"} 0`) {
+			t.Error("Probe output does not contain target labels")
+		}
+	})
+
+	t.Run("failed login", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/probe?target="+host+"&module=wrong", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), "msa_up 0") {
+			t.Error("Expected msa_up 0 for failed login")
+		}
+	})
+}
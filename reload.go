@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	configReloadSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: prefix + "exporter_config_last_reload_successful",
+		Help: "Whether the last configuration reload attempt was successful",
+	})
+	configReloadSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: prefix + "exporter_config_last_reload_success_timestamp_seconds",
+		Help: "Timestamp of the last successful configuration reload",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(configReloadSuccess, configReloadSeconds)
+}
+
+// configLoader loads the configuration file, the command line shorthand
+// and the metric definitions into a validated configuration
+type configLoader struct {
+	configFile   string
+	metricsFiles []string
+	hostname     string
+	login        string
+	password     string
+	passwordFile string
+	timeout      time.Duration
+	// tlsConfig applies to the target given by hostname
+	tlsConfig TLSConfig
+}
+
+// Load reads and validates everything the exporter is configured with
+func (l *configLoader) Load() (*Config, map[string]MetricDefinition, error) {
+	cfg := &Config{}
+	if l.configFile != "" {
+		var err error
+		if cfg, err = LoadConfig(l.configFile); err != nil {
+			return nil, nil, err
+		}
+	}
+	if cfg.AuthModules == nil {
+		cfg.AuthModules = make(map[string]AuthModule)
+	}
+
+	// The command line flags act as a single-target shorthand
+	if _, ok := cfg.AuthModules[defaultModule]; !ok && l.login != "" && (l.password != "" || l.passwordFile != "") {
+		cfg.AuthModules[defaultModule] = AuthModule{Login: l.login, Password: l.password, PasswordFile: l.passwordFile}
+	}
+	if l.hostname != "" {
+		cfg.Targets = append(cfg.Targets, TargetConfig{
+			Host:         l.hostname,
+			Login:        l.login,
+			Password:     l.password,
+			PasswordFile: l.passwordFile,
+			TLSConfig:    l.tlsConfig,
+			Retry:        DefaultRetryConfig,
+		})
+	}
+	cfg.ApplyDefaults(l.timeout)
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	metrics, err := LoadMetricDefinitions(l.metricsFiles)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cfg.ValidateLabels(metrics); err != nil {
+		return nil, nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return cfg, metrics, nil
+}
+
+// SafeConfig holds the active configuration and metric definitions
+// and swaps them atomically on reload
+type SafeConfig struct {
+	mu      sync.RWMutex
+	cfg     *Config
+	metrics map[string]MetricDefinition
+	loader  *configLoader
+}
+
+// NewSafeConfig performs the initial load of the configuration
+func NewSafeConfig(loader *configLoader) (*SafeConfig, error) {
+	sc := &SafeConfig{loader: loader}
+	if err := sc.Reload(); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+// Get returns the active configuration and metric definitions
+func (sc *SafeConfig) Get() (*Config, map[string]MetricDefinition) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.cfg, sc.metrics
+}
+
+// Reload re-reads the configuration. On error the previous configuration stays active.
+func (sc *SafeConfig) Reload() error {
+	cfg, metrics, err := sc.loader.Load()
+	if err != nil {
+		configReloadSuccess.Set(0)
+		return err
+	}
+
+	sc.mu.Lock()
+	sc.cfg = cfg
+	sc.metrics = metrics
+	sc.mu.Unlock()
+
+	configReloadSuccess.Set(1)
+	configReloadSeconds.SetToCurrentTime()
+	return nil
+}
+
+// reloadHandler serves POST /-/reload requests
+func reloadHandler(reload func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			w.Header().Set("Allow", "POST, PUT")
+			http.Error(w, "only POST or PUT requests allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := reload(); err != nil {
+			slog.Error("Failed to reload configuration", "err", err)
+			http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+			return
+		}
+		slog.Info("Configuration reloaded")
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestConfigLoader(t *testing.T) {
+	t.Run("command line shorthand", func(t *testing.T) {
+		loader := &configLoader{
+			hostname: "msa1.example.com",
+			login:    "admin",
+			password: "secret",
+			timeout:  30 * time.Second,
+		}
+		cfg, metrics, err := loader.Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if len(metrics) != len(getMetrics()) {
+			t.Errorf("Expected %d metrics, got %d", len(getMetrics()), len(metrics))
+		}
+		target, ok := cfg.FindTarget("msa1.example.com")
+		if !ok {
+			t.Fatal("Target from flags not found")
+		}
+		if time.Duration(target.Timeout) != 30*time.Second {
+			t.Errorf("Expected timeout 30s, got %v", target.Timeout)
+		}
+		if _, ok := cfg.AuthModules[defaultModule]; !ok {
+			t.Error("Expected default auth module from flags")
+		}
+	})
+
+	t.Run("hostname without credentials", func(t *testing.T) {
+		loader := &configLoader{hostname: "msa1.example.com", timeout: time.Second}
+		if _, _, err := loader.Load(); err == nil {
+			t.Error("Expected error for hostname without credentials")
+		}
+	})
+
+	t.Run("config file keeps its default module", func(t *testing.T) {
+		loader := &configLoader{
+			configFile: writeTestFile(t, "config.yml", `
+auth_modules:
+  default:
+    login: monitor
+    password: file-secret
+`),
+			login:    "admin",
+			password: "flag-secret",
+			timeout:  time.Second,
+		}
+		cfg, _, err := loader.Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.AuthModules[defaultModule].Login != "monitor" {
+			t.Errorf("Flags should not override the default module from the file")
+		}
+	})
+
+	t.Run("target label used by a metric", func(t *testing.T) {
+		for _, label := range []string{"pool", "serial", "controller", "bundle_version"} {
+			loader := &configLoader{
+				configFile: writeTestFile(t, "config.yml", `
+targets:
+  - name: msa1
+    host: msa1.example.com
+    login: admin
+    password: secret
+    labels:
+      `+label+`: x
+`),
+				timeout: time.Second,
+			}
+			_, _, err := loader.Load()
+			if err == nil || !strings.Contains(err.Error(), `label "`+label+`" is already a label of metric`) {
+				t.Errorf("Expected collision error for label %q, got %v", label, err)
+			}
+		}
+	})
+}
+
+func TestSafeConfigReload(t *testing.T) {
+	configFile := writeTestFile(t, "config.yml", `
+targets:
+  - name: msa1
+    host: msa1.example.com
+    login: admin
+    password: secret
+`)
+	sc, err := NewSafeConfig(&configLoader{configFile: configFile, timeout: time.Second})
+	if err != nil {
+		t.Fatalf("NewSafeConfig failed: %v", err)
+	}
+	if testutil.ToFloat64(configReloadSuccess) != 1 {
+		t.Error("Expected successful reload metric after initial load")
+	}
+
+	t.Run("valid change", func(t *testing.T) {
+		if err := os.WriteFile(configFile, []byte(`
+targets:
+  - name: msa2
+    host: msa2.example.com
+    login: admin
+    password: secret
+`), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := sc.Reload(); err != nil {
+			t.Fatalf("Reload failed: %v", err)
+		}
+		cfg, _ := sc.Get()
+		if _, ok := cfg.FindTarget("msa2"); !ok {
+			t.Error("Reloaded configuration was not applied")
+		}
+	})
+
+	t.Run("invalid change keeps old config", func(t *testing.T) {
+		if err := os.WriteFile(configFile, []byte(`
+targets:
+  - name: msa3
+`), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := sc.Reload(); err == nil {
+			t.Fatal("Expected reload to fail")
+		}
+		cfg, _ := sc.Get()
+		if _, ok := cfg.FindTarget("msa2"); !ok {
+			t.Error("Previous configuration should stay active")
+		}
+		if testutil.ToFloat64(configReloadSuccess) != 0 {
+			t.Error("Expected failed reload metric")
+		}
+	})
+
+	t.Run("metrics file adding a target label", func(t *testing.T) {
+		if err := os.WriteFile(configFile, []byte(`
+targets:
+  - name: msa2
+    host: msa2.example.com
+    login: admin
+    password: secret
+    labels:
+      site: dc1
+`), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := sc.Reload(); err != nil {
+			t.Fatalf("Reload failed: %v", err)
+		}
+
+		sc.loader.metricsFiles = []string{writeTestFile(t, "metrics.yml", `
+metrics:
+  system_site:
+    description: Site
+    sources:
+      - path: system
+        object_selector: system-information
+        property_selector: health-numeric
+        properties_as_label:
+          site-name: site
+`)}
+		if err := sc.Reload(); err == nil || !strings.Contains(err.Error(), `label "site" is already a label of metric "system_site"`) {
+			t.Fatalf("Expected collision error, got %v", err)
+		}
+		if _, metrics := sc.Get(); metrics["system_site"].Description != "" {
+			t.Error("Previous metric definitions should stay active")
+		}
+	})
+}
+
+func TestReloadHandler(t *testing.T) {
+	var reloadErr error
+	calls := 0
+	handler := reloadHandler(func() error {
+		calls++
+		return reloadErr
+	})
+
+	t.Run("GET not allowed", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/-/reload", nil))
+		if rr.Code != http.StatusMethodNotAllowed {
+			t.Errorf("Expected status %d, got %d", http.StatusMethodNotAllowed, rr.Code)
+		}
+		if calls != 0 {
+			t.Error("Reload should not be called for GET requests")
+		}
+	})
+
+	t.Run("successful reload", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("POST", "/-/reload", nil))
+		if rr.Code != http.StatusOK {
+			t.Errorf("Expected status %d, got %d", http.StatusOK, rr.Code)
+		}
+		if calls != 1 {
+			t.Errorf("Expected 1 reload call, got %d", calls)
+		}
+	})
+
+	t.Run("failed reload", func(t *testing.T) {
+		reloadErr = os.ErrNotExist
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("POST", "/-/reload", nil))
+		if rr.Code != http.StatusInternalServerError {
+			t.Errorf("Expected status %d, got %d", http.StatusInternalServerError, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), "failed to reload config") {
+			t.Errorf("Unexpected body: %s", rr.Body.String())
+		}
+	})
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// maxRetryBackoff caps the delay between retries of a request
+const maxRetryBackoff = 10 * time.Second
+
+// retryable reports whether a failed show request may succeed when repeated.
+// Server errors and network failures are retried; login failures, rejected
+// sessions, client errors and malformed responses are not.
+func retryable(err error) bool {
+	var statusErr *statusError
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.Is(err, errLoginFailed), errors.Is(err, errSessionExpired), errors.Is(err, errErrorResponse), errors.Is(err, errInvalidResponse):
+		return false
+	case errors.As(err, &statusErr):
+		return statusErr.code >= http.StatusInternalServerError
+	default:
+		return true
+	}
+}
+
+// retryDelay returns the jittered exponential backoff before retry attempt n,
+// counting from 0. The delay is between half and all of base*2^n.
+func retryDelay(base time.Duration, attempt int) time.Duration {
+	delay := base
+	for i := 0; i < attempt && delay < maxRetryBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxRetryBackoff)
+	return delay/2 + rand.N(delay/2+1)
+}
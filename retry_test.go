@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{&statusError{code: http.StatusServiceUnavailable}, true},
+		{&statusError{code: http.StatusNotFound}, false},
+		{errors.New("connection reset by peer"), true},
+		{fmt.Errorf("%w: %w", errLoginFailed, errors.New("authentication failed")), false},
+		{fmt.Errorf("%w: %w", errSessionExpired, &statusError{code: http.StatusUnauthorized}), false},
+		{fmt.Errorf("%w: Command not recognized", errErrorResponse), false},
+		{fmt.Errorf("%w: unexpected EOF", errInvalidResponse), false},
+		{context.Canceled, false},
+	}
+
+	for _, tt := range tests {
+		if retryable(tt.err) != tt.expected {
+			t.Errorf("retryable(%v) = %v, expected %v", tt.err, !tt.expected, tt.expected)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	base := 100 * time.Millisecond
+	for attempt := 0; attempt < 4; attempt++ {
+		full := base << attempt
+		for i := 0; i < 20; i++ {
+			if delay := retryDelay(base, attempt); delay < full/2 || delay > full {
+				t.Errorf("Delay %v of attempt %d outside [%v, %v]", delay, attempt, full/2, full)
+			}
+		}
+	}
+	if delay := retryDelay(base, 100); delay > maxRetryBackoff {
+		t.Errorf("Delay %v exceeds the maximum backoff", delay)
+	}
+}
+
+func TestMSAClientRetries(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/login/" + getSHA256("retryuser_retrypass"):
+			_, _ = w.Write([]byte(`<RESPONSE><OBJECT name="status"><PROPERTY name="response">key</PROPERTY></OBJECT></RESPONSE>`))
+		case "/api/show/busy":
+			// The controller is busy for the first two requests
+			if requests.Add(1) <= 2 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = w.Write([]byte(`<RESPONSE></RESPONSE>`))
+		default:
+			requests.Add(1)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := newMSAClient(server.URL[8:], "retryuser", "retrypass", 10*time.Second, &tls.Config{InsecureSkipVerify: true})
+	client.maxRetries = 2
+	client.retryBackoff = time.Millisecond
+
+	if _, err := client.GetDocument(t.Context(), "busy"); err != nil {
+		t.Fatalf("Expected request to succeed after retries: %v", err)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("Expected 3 requests, got %d", n)
+	}
+
+	requests.Store(0)
+	if _, err := client.GetDocument(t.Context(), "missing"); err == nil {
+		t.Error("Expected error for missing path")
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("Expected client errors not to be retried, got %d requests", n)
+	}
+}
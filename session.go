@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sessionLimiter caps the number of sessions open on one array
+type sessionLimiter struct {
+	mu   sync.Mutex
+	host string
+	max  int
+	open int
+}
+
+// acquire reserves a session slot
+func (l *sessionLimiter) acquire() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.max > 0 && l.open >= l.max {
+		return fmt.Errorf("session limit of %d reached for %s", l.max, l.host)
+	}
+	l.open++
+	return nil
+}
+
+// release frees a session slot
+func (l *sessionLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.open > 0 {
+		l.open--
+	}
+}
+
+// setMax changes the session cap, 0 means unlimited
+func (l *sessionLimiter) setMax(max int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.max = max
+}
+
+// clientSettings are the target options a client is built from.
+// A change in any of them requires a new client and session.
+type clientSettings struct {
+	// hosts are the management addresses joined by commas, keeping the struct comparable
+	hosts        string
+	failback     time.Duration
+	login        string
+	password     string
+	passwordFile string
+	timeout      time.Duration
+	concurrency  int
+	tlsConfig    TLSConfig
+	retry        RetryConfig
+}
+
+// pooledClient is a long-lived client together with its settings
+type pooledClient struct {
+	settings clientSettings
+	client   *MSAClient
+	// lastUsed is when the client was last handed out, guarded by the pool's mutex
+	lastUsed time.Time
+}
+
+// clientPool keeps one long-lived MSAClient per target so session keys
+// and keep-alive connections are reused between scrapes. Clients for the
+// same array share a session limiter.
+type clientPool struct {
+	mu       sync.Mutex
+	clients  map[string]*pooledClient
+	limiters map[string]*sessionLimiter
+	// limits are the session caps of the configured arrays by address
+	limits map[string]int
+	// configured are the names of the configured targets, whose clients
+	// are never evicted
+	configured map[string]bool
+}
+
+// newClientPool creates an empty client pool
+func newClientPool() *clientPool {
+	return &clientPool{
+		clients:    make(map[string]*pooledClient),
+		limiters:   make(map[string]*sessionLimiter),
+		limits:     make(map[string]int),
+		configured: make(map[string]bool),
+	}
+}
+
+// SetTargets sets the session caps from the configured targets. Targets
+// sharing an address are held to the lowest non-zero cap; ad-hoc probe
+// targets never change the caps.
+func (p *clientPool) SetTargets(targets []TargetConfig) {
+	limits := make(map[string]int)
+	configured := make(map[string]bool)
+	for _, target := range targets {
+		configured[target.Name] = true
+		address := target.Addresses()[0]
+		if target.MaxSessions > 0 && (limits[address] == 0 || target.MaxSessions < limits[address]) {
+			limits[address] = target.MaxSessions
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.limits = limits
+	p.configured = configured
+	for address, limiter := range p.limiters {
+		limiter.setMax(limits[address])
+	}
+}
+
+// Client returns the client for the named target, creating a new one
+// if there is none yet or the target settings have changed
+func (p *clientPool) Client(name string, target TargetConfig, auth AuthModule) (*MSAClient, error) {
+	settings := clientSettings{
+		hosts:        strings.Join(target.Addresses(), ","),
+		failback:     time.Duration(target.FailbackAfter),
+		login:        auth.Login,
+		password:     auth.Password,
+		passwordFile: auth.PasswordFile,
+		timeout:      time.Duration(target.Timeout),
+		concurrency:  target.Concurrency,
+		tlsConfig:    target.TLSConfig,
+		retry:        target.Retry,
+	}
+
+	client, replaced, err := p.clientLocked(name, target, settings)
+	if replaced != nil {
+		// The replaced client's session must not linger on the array
+		logoutDropped(replaced)
+	}
+	return client, err
+}
+
+// clientLocked returns the pooled client and the client it replaced, if any
+func (p *clientPool) clientLocked(name string, target TargetConfig, settings clientSettings) (*MSAClient, *MSAClient, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Sessions are limited per array, identified by its first management address
+	addresses := target.Addresses()
+	limiter, ok := p.limiters[addresses[0]]
+	if !ok {
+		limiter = &sessionLimiter{host: addresses[0], max: p.limits[addresses[0]]}
+		p.limiters[addresses[0]] = limiter
+	}
+
+	adhoc := !p.configured[target.Name]
+	pooled, ok := p.clients[name]
+	if ok && reflect.DeepEqual(pooled.settings, settings) && pooled.client.adhoc == adhoc {
+		pooled.lastUsed = time.Now()
+		return pooled.client, nil, nil
+	}
+
+	tlsConfig, err := target.TLSConfig.Build()
+	if err != nil {
+		return nil, nil, err
+	}
+	if target.TLSConfig.InsecureSkipVerify {
+		slog.Warn("TLS certificate verification is disabled", "target", target.Name)
+	}
+	var replaced *MSAClient
+	if ok {
+		replaced = pooled.client
+	}
+	client := newMSAClient(addresses[0], settings.login, settings.password, settings.timeout, tlsConfig)
+	client.target = target.Name
+	client.passwordFile = settings.passwordFile
+	client.hosts = addresses
+	client.failbackAfter = settings.failback
+	client.adhoc = adhoc
+	if !client.adhoc {
+		activeController.DeletePartialMatch(prometheus.Labels{"target": target.Name})
+	}
+	client.reportControllerLocked()
+	client.limiter = limiter
+	client.concurrency = settings.concurrency
+	client.maxRetries = settings.retry.MaxRetries
+	client.retryBackoff = time.Duration(settings.retry.Backoff)
+	client.breaker = newCircuitBreaker(target.Name, settings.retry.LoginFailureThreshold, time.Duration(settings.retry.LoginBackoff))
+	if client.breaker != nil {
+		client.breaker.adhoc = client.adhoc
+	}
+	p.clients[name] = &pooledClient{settings: settings, client: client, lastUsed: time.Now()}
+	return client, replaced, nil
+}
+
+// SessionState returns the session state of the named target's client
+func (p *clientPool) SessionState(name string) (sessionState, bool) {
+	p.mu.Lock()
+	pooled, ok := p.clients[name]
+	p.mu.Unlock()
+	if !ok {
+		return sessionState{}, false
+	}
+	return pooled.client.SessionState(), true
+}
+
+// Remove drops the clients of the named target, including those probed
+// with another auth module, and logs them out once the pool is unlocked
+func (p *clientPool) Remove(name string) {
+	p.mu.Lock()
+	var removed []*MSAClient
+	for key, pooled := range p.clients {
+		if key == name || strings.HasPrefix(key, name+"?module=") {
+			removed = append(removed, pooled.client)
+			delete(p.clients, key)
+		}
+	}
+	p.mu.Unlock()
+	for _, client := range removed {
+		logoutDropped(client)
+	}
+}
+
+// EvictIdle drops and logs out the clients that were not handed out
+// within maxIdle, such as those of addresses no longer probed, and
+// returns their number. Clients of configured targets are kept.
+func (p *clientPool) EvictIdle(maxIdle time.Duration) int {
+	p.mu.Lock()
+	var evicted []*MSAClient
+	for key, pooled := range p.clients {
+		if !p.configured[key] && time.Since(pooled.lastUsed) > maxIdle {
+			evicted = append(evicted, pooled.client)
+			delete(p.clients, key)
+		}
+	}
+
+	// Forget the limiters of arrays without clients
+	inUse := make(map[*sessionLimiter]bool, len(p.clients))
+	for _, pooled := range p.clients {
+		inUse[pooled.client.limiter] = true
+	}
+	for address, limiter := range p.limiters {
+		if !inUse[limiter] {
+			delete(p.limiters, address)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, client := range evicted {
+		logoutDropped(client)
+	}
+	return len(evicted)
+}
+
+// RunEviction evicts idle clients every maxIdle until ctx is cancelled
+func (p *clientPool) RunEviction(ctx context.Context, maxIdle time.Duration) {
+	ticker := time.NewTicker(maxIdle)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n := p.EvictIdle(maxIdle); n > 0 {
+				slog.Debug("Evicted idle clients", "count", n)
+			}
+		}
+	}
+}
+
+// logoutDropped ends the session of a client removed from the pool,
+// waiting no longer than the client's request timeout
+func logoutDropped(client *MSAClient) {
+	ctx, cancel := context.WithTimeout(context.Background(), client.timeout)
+	defer cancel()
+	client.Logout(ctx)
+}
+
+// Close logs out all sessions held by the pool, giving up when ctx is done
+func (p *clientPool) Close(ctx context.Context) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var wg sync.WaitGroup
+	for name, pooled := range p.clients {
+		wg.Add(1)
+		go func(client *MSAClient) {
+			defer wg.Done()
+			client.Logout(ctx)
+		}(pooled.client)
+		delete(p.clients, name)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+func TestClientPool(t *testing.T) {
+	pool := newClientPool()
+	target := TargetConfig{
+		Name:      "msa1",
+		Host:      "msa1.example.com",
+		Timeout:   model.Duration(10 * time.Second),
+		TLSConfig: testTLSConfig,
+	}
+	auth := AuthModule{Login: "admin", Password: "secret"}
+
+	first, err := pool.Client("msa1", target, auth)
+	if err != nil {
+		t.Fatalf("Client failed: %v", err)
+	}
+
+	t.Run("same settings reuse the client", func(t *testing.T) {
+		second, err := pool.Client("msa1", target, auth)
+		if err != nil {
+			t.Fatalf("Client failed: %v", err)
+		}
+		if second != first {
+			t.Error("Expected the same client for unchanged settings")
+		}
+	})
+
+	t.Run("changed credentials create a new client", func(t *testing.T) {
+		changed, err := pool.Client("msa1", target, AuthModule{Login: "admin", Password: "rotated"})
+		if err != nil {
+			t.Fatalf("Client failed: %v", err)
+		}
+		if changed == first {
+			t.Error("Expected a new client after the password changed")
+		}
+	})
+
+	t.Run("invalid TLS settings", func(t *testing.T) {
+		broken := target
+		broken.TLSConfig.CAFile = "/nonexistent/ca.pem"
+		if _, err := pool.Client("msa2", broken, auth); err == nil {
+			t.Error("Expected error for invalid TLS settings")
+		}
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		pool.Remove("msa1")
+		if _, ok := pool.clients["msa1"]; ok {
+			t.Error("Client was not removed")
+		}
+	})
+}
+
+// newSessionTestServer returns a mock array counting logins and logouts
+func newSessionTestServer(t *testing.T, logins, logouts *atomic.Int32) *httptest.Server {
+	t.Helper()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/api/login/"):
+			n := logins.Add(1)
+			_, _ = fmt.Fprintf(w, `<RESPONSE><OBJECT name="status"><PROPERTY name="response">key-%d</PROPERTY></OBJECT></RESPONSE>`, n)
+		case r.URL.Path == "/api/exit":
+			if r.Header.Get("sessionKey") == "" {
+				t.Error("Logout without session key")
+			}
+			logouts.Add(1)
+			_, _ = w.Write([]byte(`<RESPONSE></RESPONSE>`))
+		default:
+			_, _ = w.Write([]byte(`<RESPONSE></RESPONSE>`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClientPoolLogout(t *testing.T) {
+	var logins, logouts atomic.Int32
+	server := newSessionTestServer(t, &logins, &logouts)
+
+	pool := newClientPool()
+	target := TargetConfig{
+		Name:      "msa1",
+		Host:      server.URL[8:],
+		Timeout:   model.Duration(10 * time.Second),
+		TLSConfig: testTLSConfig,
+	}
+	auth := AuthModule{Login: "admin", Password: "secret"}
+
+	client, err := pool.Client("msa1", target, auth)
+	if err != nil {
+		t.Fatalf("Client failed: %v", err)
+	}
+	if _, err := client.GetDocument(t.Context(), "system"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	t.Run("replaced client logs out", func(t *testing.T) {
+		if _, err := pool.Client("msa1", target, AuthModule{Login: "admin", Password: "rotated"}); err != nil {
+			t.Fatalf("Client failed: %v", err)
+		}
+		if logouts.Load() != 1 {
+			t.Errorf("Expected 1 logout, got %d", logouts.Load())
+		}
+	})
+
+	t.Run("re-login logs out the old session", func(t *testing.T) {
+		if err := client.Login(t.Context()); err != nil {
+			t.Fatalf("Login failed: %v", err)
+		}
+		if err := client.Login(t.Context()); err != nil {
+			t.Fatalf("Login failed: %v", err)
+		}
+		if logouts.Load() != 2 {
+			t.Errorf("Expected 2 logouts, got %d", logouts.Load())
+		}
+		client.Logout(t.Context())
+	})
+
+	t.Run("close logs out all sessions", func(t *testing.T) {
+		before := logouts.Load()
+		for _, name := range []string{"msa1", "msa1?module=other"} {
+			c, err := pool.Client(name, target, auth)
+			if err != nil {
+				t.Fatalf("Client failed: %v", err)
+			}
+			if _, err := c.GetDocument(t.Context(), "system"); err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+		}
+		pool.Close(t.Context())
+		if logouts.Load()-before != 2 {
+			t.Errorf("Expected 2 logouts on close, got %d", logouts.Load()-before)
+		}
+		if len(pool.clients) != 0 {
+			t.Error("Pool should be empty after close")
+		}
+	})
+
+	t.Run("logout without session is a no-op", func(t *testing.T) {
+		before := logouts.Load()
+		newMSAClient(target.Host, "admin", "secret", time.Second, &tls.Config{InsecureSkipVerify: true}).Logout(t.Context())
+		if logouts.Load() != before {
+			t.Error("Logout without a session should not call the array")
+		}
+	})
+}
+
+func TestClientPoolRemoveUnlocked(t *testing.T) {
+	exiting := make(chan struct{})
+	release := make(chan struct{})
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/api/login/"):
+			_, _ = w.Write([]byte(`<RESPONSE><OBJECT name="status"><PROPERTY name="response">key</PROPERTY></OBJECT></RESPONSE>`))
+		case r.URL.Path == "/api/exit":
+			// An unreachable array holds the logout until it times out
+			close(exiting)
+			<-release
+		default:
+			_, _ = w.Write([]byte(`<RESPONSE></RESPONSE>`))
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	pool := newClientPool()
+	target := TargetConfig{Name: "msa1", Host: server.URL[8:], Timeout: model.Duration(10 * time.Second), TLSConfig: testTLSConfig}
+	auth := AuthModule{Login: "admin", Password: "secret"}
+	client, err := pool.Client("msa1", target, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Login(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	go pool.Remove("msa1")
+	<-exiting
+
+	// Other targets get their clients while the logout is pending
+	done := make(chan error, 1)
+	go func() {
+		other := TargetConfig{Name: "msa2", Host: "msa2.example.com", Timeout: model.Duration(time.Second), TLSConfig: testTLSConfig}
+		_, err := pool.Client("msa2", other, auth)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Client failed: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Pool was locked during logout")
+	}
+}
+
+func TestClientPoolRemoveModules(t *testing.T) {
+	var logins, logouts atomic.Int32
+	server := newSessionTestServer(t, &logins, &logouts)
+
+	pool := newClientPool()
+	target := TargetConfig{Name: "msa1", Host: server.URL[8:], Timeout: model.Duration(10 * time.Second), TLSConfig: testTLSConfig}
+	for _, key := range []string{"msa1", "msa1?module=monitor", "msa10"} {
+		client, err := pool.Client(key, target, AuthModule{Login: "admin", Password: "secret"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Login(t.Context()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Clients probed with other auth modules go with their target
+	pool.Remove("msa1")
+	if logouts.Load() != 2 {
+		t.Errorf("Expected 2 logouts, got %d", logouts.Load())
+	}
+	if _, ok := pool.SessionState("msa1?module=monitor"); ok {
+		t.Error("Expected the module client to be removed")
+	}
+	if _, ok := pool.SessionState("msa10"); !ok {
+		t.Error("Expected the client of another target to be kept")
+	}
+}
+
+func TestClientPoolEvictIdle(t *testing.T) {
+	var logins, logouts atomic.Int32
+	server := newSessionTestServer(t, &logins, &logouts)
+
+	pool := newClientPool()
+	configured := TargetConfig{Name: "msa1", Host: server.URL[8:], Timeout: model.Duration(10 * time.Second), TLSConfig: testTLSConfig}
+	pool.SetTargets([]TargetConfig{configured})
+	adhoc := configured
+	adhoc.Name = server.URL[8:]
+
+	auth := AuthModule{Login: "admin", Password: "secret"}
+	for _, key := range []string{"msa1", "msa1?module=monitor", adhoc.Name} {
+		target := configured
+		if key == adhoc.Name {
+			target = adhoc
+		}
+		client, err := pool.Client(key, target, auth)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Login(t.Context()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := pool.EvictIdle(time.Hour); n != 0 {
+		t.Errorf("Expected no recently used client to be evicted, got %d", n)
+	}
+
+	// Only the configured target's client survives being idle
+	if n := pool.EvictIdle(0); n != 2 {
+		t.Errorf("Expected 2 evicted clients, got %d", n)
+	}
+	if logouts.Load() != 2 {
+		t.Errorf("Expected 2 logouts, got %d", logouts.Load())
+	}
+	if _, ok := pool.SessionState("msa1"); !ok {
+		t.Error("Expected the configured target's client to be kept")
+	}
+	if len(pool.limiters) != 1 {
+		t.Errorf("Expected the limiter of the configured array to be kept, got %d", len(pool.limiters))
+	}
+}
+
+func TestClientPoolAdhocSelfMetrics(t *testing.T) {
+	var logins, logouts atomic.Int32
+	server := newSessionTestServer(t, &logins, &logouts)
+
+	pool := newClientPool()
+	defer pool.Close(t.Context())
+	configured := TargetConfig{Name: "adhoc-metrics-test", Host: server.URL[8:], Timeout: model.Duration(10 * time.Second), TLSConfig: testTLSConfig}
+	pool.SetTargets([]TargetConfig{configured})
+	adhoc := configured
+	adhoc.Name = server.URL[8:]
+	defer deleteTargetMetrics(configured.Name)
+	defer deleteTargetMetrics(adhoc.Name)
+
+	auth := AuthModule{Login: "admin", Password: "secret"}
+	for _, target := range []TargetConfig{configured, adhoc} {
+		client, err := pool.Client(target.Name, target, auth)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.GetDocument(t.Context(), "system"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	series := func(name string) int {
+		labels := prometheus.Labels{"target": name}
+		return apiRequestDuration.DeletePartialMatch(labels) + activeController.DeletePartialMatch(labels)
+	}
+	if n := series(configured.Name); n != 2 {
+		t.Errorf("Expected the configured target to be recorded, got %d series", n)
+	}
+	if n := series(adhoc.Name); n != 0 {
+		t.Errorf("Expected the ad-hoc target not to be recorded, got %d series", n)
+	}
+}
+
+func TestSessionLimit(t *testing.T) {
+	var logins, logouts atomic.Int32
+	server := newSessionTestServer(t, &logins, &logouts)
+
+	pool := newClientPool()
+	target := TargetConfig{
+		Name:        "msa1",
+		Host:        server.URL[8:],
+		Timeout:     model.Duration(10 * time.Second),
+		TLSConfig:   testTLSConfig,
+		MaxSessions: 1,
+	}
+	pool.SetTargets([]TargetConfig{target})
+
+	first, err := pool.Client("msa1", target, AuthModule{Login: "admin", Password: "secret"})
+	if err != nil {
+		t.Fatalf("Client failed: %v", err)
+	}
+	second, err := pool.Client("msa1?module=other", target, AuthModule{Login: "monitor", Password: "secret"})
+	if err != nil {
+		t.Fatalf("Client failed: %v", err)
+	}
+
+	if _, err := first.GetDocument(t.Context(), "system"); err != nil {
+		t.Fatalf("First session failed: %v", err)
+	}
+	_, err = second.GetDocument(t.Context(), "system")
+	if err == nil || !strings.Contains(err.Error(), "session limit of 1 reached") {
+		t.Fatalf("Expected session limit error, got %v", err)
+	}
+
+	// Re-login replaces the session and keeps within the limit
+	if err := first.Login(t.Context()); err != nil {
+		t.Errorf("Re-login should not be blocked by the limit: %v", err)
+	}
+
+	first.Logout(t.Context())
+	if _, err := second.GetDocument(t.Context(), "system"); err != nil {
+		t.Errorf("Expected session after the first one logged out: %v", err)
+	}
+	pool.Close(t.Context())
+}
+
+func TestSetTargets(t *testing.T) {
+	pool := newClientPool()
+	auth := AuthModule{Login: "admin", Password: "secret"}
+	configured := []TargetConfig{
+		{Name: "msa1", Host: "msa.example.com", Timeout: model.Duration(time.Second), TLSConfig: testTLSConfig, MaxSessions: 4},
+		{Name: "msa1-monitor", Host: "msa.example.com", Timeout: model.Duration(time.Second), TLSConfig: testTLSConfig, MaxSessions: 2},
+		{Name: "msa2", Host: "msa2.example.com", Timeout: model.Duration(time.Second), TLSConfig: testTLSConfig},
+	}
+	pool.SetTargets(configured)
+
+	// Targets sharing an address get the lowest cap
+	client, err := pool.Client("msa1", configured[0], auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.limiter.max != 2 {
+		t.Errorf("Expected a cap of 2 sessions, got %d", client.limiter.max)
+	}
+
+	// Probing the address of a configured array keeps its cap
+	adhoc := TargetConfig{Name: "msa.example.com", Host: "msa.example.com", Timeout: model.Duration(time.Second), TLSConfig: testTLSConfig}
+	probed, err := pool.Client(adhoc.Name, adhoc, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if probed.limiter != client.limiter || client.limiter.max != 2 {
+		t.Errorf("Expected the ad-hoc target to share the cap of 2, got %d", client.limiter.max)
+	}
+
+	// A reload applies new caps to existing limiters
+	pool.SetTargets(configured[2:])
+	if client.limiter.max != 0 {
+		t.Errorf("Expected no cap after the targets were removed, got %d", client.limiter.max)
+	}
+}
+
+func TestClientPasswordFile(t *testing.T) {
+	var current atomic.Value
+	current.Store("first")
+	var logins atomic.Int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/login/" + getSHA256("admin_"+current.Load().(string)):
+			logins.Add(1)
+			_, _ = w.Write([]byte(`<RESPONSE><OBJECT name="status"><PROPERTY name="response">key</PROPERTY></OBJECT></RESPONSE>`))
+		case "/api/show/system":
+			if r.Header.Get("sessionKey") == "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = w.Write([]byte(`<RESPONSE></RESPONSE>`))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	passwordFile := writeTestFile(t, "password", "first\n")
+	target := TargetConfig{
+		Name:      "password-file-test",
+		Host:      server.URL[8:],
+		Timeout:   model.Duration(10 * time.Second),
+		TLSConfig: testTLSConfig,
+	}
+	client, err := newClientPool().Client(target.Name, target, AuthModule{Login: "admin", PasswordFile: passwordFile})
+	if err != nil {
+		t.Fatalf("Client failed: %v", err)
+	}
+	if err := client.Login(t.Context()); err != nil {
+		t.Fatalf("Login with password file failed: %v", err)
+	}
+
+	// The array rejects the old password until the secret is rotated
+	current.Store("second")
+	if err := client.Login(t.Context()); err == nil {
+		t.Fatal("Expected login with the old password to fail")
+	}
+	if err := os.WriteFile(passwordFile, []byte("second\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Login(t.Context()); err != nil {
+		t.Fatalf("Expected rotated password to be picked up: %v", err)
+	}
+	if logins.Load() != 2 {
+		t.Errorf("Expected 2 successful logins, got %d", logins.Load())
+	}
+}
+
+func TestLoginErrorsHideHash(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	host := server.URL[8:]
+	server.Close()
+
+	_, err := newLoggedInClient(t.Context(), host, "admin", "secret", time.Second, &tls.Config{InsecureSkipVerify: true})
+	if err == nil {
+		t.Fatal("Expected login to a closed server to fail")
+	}
+	if strings.Contains(err.Error(), getSHA256("admin_secret")) || strings.Contains(err.Error(), "secret") {
+		t.Errorf("Login error leaks credentials: %v", err)
+	}
+	if !strings.Contains(err.Error(), "/api/login/REDACTED") {
+		t.Errorf("Expected redacted login URL in %v", err)
+	}
+}
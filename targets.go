@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// targetManager runs a scrape loop for every configured target, or in
+// on-demand mode registers a collector reading the array on every scrape.
+// Metric stores are kept across reloads as long as the target exists
+// and neither its labels nor the label schemas of the metrics change.
+type targetManager struct {
+	// ctx is cancelled on shutdown and aborts all scrapes
+	ctx context.Context
+	wg  sync.WaitGroup
+	mu  sync.Mutex
+	// reloadMu serializes reloads, so the configuration loaded last is
+	// the one that is active and synced
+	reloadMu sync.Mutex
+	state    *SafeConfig
+	pool     *clientPool
+	interval time.Duration
+	targets  map[string]*managedTarget
+	// schemas are the metric schemas the registries were created for
+	schemas map[string]string
+
+	// mode is modeBackground or modeOnDemand, cacheTTL applies to the latter
+	mode     string
+	cacheTTL time.Duration
+}
+
+// managedTarget is the runtime state of a configured target
+type managedTarget struct {
+	name     string
+	labels   prometheus.Labels
+	registry *prometheus.Registry
+	store    *MetricStore
+	cancel   context.CancelFunc
+
+	// Outcome of the scrapes, guarded by the manager's mutex
+	lastScrape  time.Time
+	lastSuccess time.Time
+	lastError   string
+}
+
+// newTargetManager creates a target manager for the given configuration
+func newTargetManager(ctx context.Context, state *SafeConfig, pool *clientPool, interval time.Duration) *targetManager {
+	return &targetManager{
+		ctx:      ctx,
+		state:    state,
+		pool:     pool,
+		interval: interval,
+		targets:  make(map[string]*managedTarget),
+		mode:     modeBackground,
+	}
+}
+
+// Reload re-reads the configuration and syncs the targets with it.
+// SIGHUP and /-/reload may trigger reloads concurrently.
+func (m *targetManager) Reload() error {
+	m.reloadMu.Lock()
+	defer m.reloadMu.Unlock()
+	if err := m.state.Reload(); err != nil {
+		return err
+	}
+	m.Sync()
+	return nil
+}
+
+// Sync starts scrape loops for new targets and stops loops of removed ones
+func (m *targetManager) Sync() {
+	cfg, metrics := m.state.Get()
+	m.pool.SetTargets(cfg.Targets)
+
+	m.mu.Lock()
+	schemas := metricSchemas(metrics)
+	schemasChanged := !maps.Equal(m.schemas, schemas)
+	m.schemas = schemas
+
+	seen := make(map[string]bool)
+	for _, target := range cfg.Targets {
+		seen[target.Name] = true
+		labels := target.ConstLabels()
+		existing, ok := m.targets[target.Name]
+		if ok {
+			if maps.Equal(existing.labels, labels) && !schemasChanged {
+				continue
+			}
+			existing.cancel()
+		}
+
+		registry := prometheus.NewRegistry()
+		registerer := prometheus.WrapRegistererWith(labels, registry)
+		ctx, cancel := context.WithCancel(m.ctx)
+		mt := &managedTarget{
+			name:     target.Name,
+			labels:   labels,
+			registry: registry,
+			cancel:   cancel,
+		}
+		if ok {
+			// A label or schema change does not make the collected data stale
+			mt.lastScrape, mt.lastSuccess, mt.lastError = existing.lastScrape, existing.lastSuccess, existing.lastError
+		}
+		m.targets[target.Name] = mt
+
+		if m.mode == modeOnDemand {
+			registerer.MustRegister(newMSACollector(m, target.Name, m.cacheTTL))
+			continue
+		}
+		mt.store = NewMetricStoreWithRegisterer(registerer)
+		slog.Info("Starting scrape loop", "target", target.Name, "addresses", strings.Join(target.Addresses(), ","))
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			m.run(ctx, mt)
+		}()
+	}
+
+	var removed []string
+	for name, mt := range m.targets {
+		if !seen[name] {
+			slog.Info("Removing target", "target", name)
+			mt.cancel()
+			delete(m.targets, name)
+			deleteTargetMetrics(name)
+			removed = append(removed, name)
+		}
+	}
+	m.mu.Unlock()
+
+	// Logging out may take up to the request timeout and must not block
+	// gathering or the readiness check
+	for _, name := range removed {
+		m.pool.Remove(name)
+	}
+}
+
+// run periodically scrapes a target using the current configuration until ctx is cancelled
+func (m *targetManager) run(ctx context.Context, mt *managedTarget) {
+	for {
+		cfg, metrics := m.state.Get()
+		if target, ok := cfg.FindTarget(mt.name); ok {
+			start := time.Now()
+			auth, err := cfg.Credentials(target)
+			if err == nil {
+				err = scrapeTarget(ctx, m.pool, mt.name, target, auth, mt.store, metrics)
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				slog.Warn("Failed to scrape target", "target", mt.name, "err", err)
+			}
+			m.observe(mt.name, start, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(m.interval):
+		}
+	}
+}
+
+// Wait blocks until all scrape loops have returned after the manager's context was cancelled
+func (m *targetManager) Wait() {
+	m.wg.Wait()
+}
+
+// observe records the outcome of a scrape of the named target
+func (m *targetManager) observe(name string, start time.Time, err error) {
+	observeScrape(name, start, err)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mt, ok := m.targets[name]
+	if !ok {
+		return
+	}
+	mt.lastScrape = time.Now()
+	if err != nil {
+		mt.lastError = err.Error()
+		return
+	}
+	mt.lastSuccess = mt.lastScrape
+	mt.lastError = ""
+}
+
+// Gather implements prometheus.Gatherer over the registries of all targets.
+// The registries are gathered concurrently, so in on-demand mode a slow
+// array does not delay the collection of the others.
+func (m *targetManager) Gather() ([]*dto.MetricFamily, error) {
+	m.mu.Lock()
+	registries := make([]*prometheus.Registry, 0, len(m.targets))
+	for _, mt := range m.targets {
+		registries = append(registries, mt.registry)
+	}
+	m.mu.Unlock()
+
+	families := make([][]*dto.MetricFamily, len(registries))
+	errs := make([]error, len(registries))
+	var wg sync.WaitGroup
+	for i, registry := range registries {
+		wg.Go(func() {
+			families[i], errs[i] = registry.Gather()
+		})
+	}
+	wg.Wait()
+
+	// Gatherers merges the families and checks them for consistency
+	gatherers := make(prometheus.Gatherers, len(registries))
+	for i := range registries {
+		gatherers[i] = prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			return families[i], errs[i]
+		})
+	}
+	return gatherers.Gather()
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// waitForMetric polls the manager until the named metric family is gathered
+func waitForMetric(t *testing.T, m *targetManager, name string) int {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		families, err := m.Gather()
+		if err != nil {
+			t.Fatalf("Gather failed: %v", err)
+		}
+		for _, family := range families {
+			if family.GetName() == name {
+				return len(family.GetMetric())
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Metric %s was not gathered", name)
+	return 0
+}
+
+func TestTargetManager(t *testing.T) {
+	server := newProbeTestServer(t)
+	host := server.URL[8:]
+
+	configFile := writeTestFile(t, "config.yml", `
+targets:
+  - name: array1
+    host: `+host+`
+    login: probeuser
+    password: probepass
+    tls_config:
+      insecure_skip_verify: true
+`)
+	state, err := NewSafeConfig(&configLoader{configFile: configFile, timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("NewSafeConfig failed: %v", err)
+	}
+
+	manager := newTargetManager(t.Context(), state, newClientPool(), time.Hour)
+	manager.Sync()
+
+	if n := waitForMetric(t, manager, "msa_disk_temperature"); n != 1 {
+		t.Errorf("Expected 1 disk temperature series, got %d", n)
+	}
+	store := manager.targets["array1"].store
+
+	t.Run("unchanged target keeps its store", func(t *testing.T) {
+		if err := state.Reload(); err != nil {
+			t.Fatalf("Reload failed: %v", err)
+		}
+		manager.Sync()
+		if manager.targets["array1"].store != store {
+			t.Error("Metric store should be kept across reloads")
+		}
+	})
+
+	t.Run("changed labels restart the target", func(t *testing.T) {
+		if err := os.WriteFile(configFile, []byte(`
+targets:
+  - name: array1
+    host: `+host+`
+    login: probeuser
+    password: probepass
+    tls_config:
+      insecure_skip_verify: true
+    labels:
+      datacenter: dc1
+`), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := state.Reload(); err != nil {
+			t.Fatalf("Reload failed: %v", err)
+		}
+		manager.Sync()
+		if manager.targets["array1"].store == store {
+			t.Error("Metric store should be replaced when labels change")
+		}
+		waitForMetric(t, manager, "msa_disk_temperature")
+	})
+
+	t.Run("removed target is stopped", func(t *testing.T) {
+		if err := os.WriteFile(configFile, []byte("targets: []\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := state.Reload(); err != nil {
+			t.Fatalf("Reload failed: %v", err)
+		}
+		manager.Sync()
+		families, err := manager.Gather()
+		if err != nil {
+			t.Fatalf("Gather failed: %v", err)
+		}
+		if len(families) != 0 {
+			t.Errorf("Expected no metrics after removing the target, got %d families", len(families))
+		}
+	})
+}
+
+func TestTargetManagerShutdown(t *testing.T) {
+	server := newProbeTestServer(t)
+
+	configFile := writeTestFile(t, "config.yml", `
+targets:
+  - name: array1
+    host: `+server.URL[8:]+`
+    login: probeuser
+    password: probepass
+    tls_config:
+      insecure_skip_verify: true
+`)
+	state, err := NewSafeConfig(&configLoader{configFile: configFile, timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("NewSafeConfig failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	manager := newTargetManager(ctx, state, newClientPool(), time.Hour)
+	manager.Sync()
+	waitForMetric(t, manager, "msa_disk_temperature")
+
+	cancel()
+	stopped := make(chan struct{})
+	go func() {
+		manager.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Scrape loops did not stop after cancellation")
+	}
+}
+
+func TestTargetManagerReloadMetricDefinitions(t *testing.T) {
+	server := newProbeTestServer(t)
+
+	configFile := writeTestFile(t, "config.yml", `
+targets:
+  - name: array1
+    host: `+server.URL[8:]+`
+    login: probeuser
+    password: probepass
+    tls_config:
+      insecure_skip_verify: true
+`)
+	metricsFile := writeTestFile(t, "metrics.yml", `
+metrics:
+  disk_temperature:
+    description: Temperature
+    sources:
+      - path: disks
+        object_selector: drive
+        property_selector: temperature-numeric
+        properties_as_label:
+          location: location
+`)
+	state, err := NewSafeConfig(&configLoader{configFile: configFile, metricsFiles: []string{metricsFile}, timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("NewSafeConfig failed: %v", err)
+	}
+	manager := newTargetManager(t.Context(), state, newClientPool(), time.Hour)
+	manager.Sync()
+	waitForMetric(t, manager, "msa_disk_temperature")
+	store := manager.targets["array1"].store
+
+	// The reloaded definition adds a label to the metric
+	if err := os.WriteFile(metricsFile, []byte(`
+metrics:
+  disk_temperature:
+    description: Disk temperature
+    sources:
+      - path: disks
+        object_selector: drive
+        property_selector: temperature-numeric
+        properties_as_label:
+          location: location
+          serial-number: serial
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	manager.Sync()
+	if manager.targets["array1"].store == store {
+		t.Fatal("Metric store should be replaced when a label schema changes")
+	}
+	waitForMetric(t, manager, "msa_disk_temperature")
+
+	expected := `
+# HELP msa_disk_temperature Disk temperature
+# TYPE msa_disk_temperature gauge
+msa_disk_temperature{location="1.1",serial="PROBE123",target="array1"} 38
+`
+	if err := testutil.GatherAndCompare(manager, strings.NewReader(expected), "msa_disk_temperature"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTargetManagerConcurrentReloads(t *testing.T) {
+	server := newProbeTestServer(t)
+	host := server.URL[8:]
+
+	configFile := writeTestFile(t, "config.yml", "targets: []\n")
+	state, err := NewSafeConfig(&configLoader{configFile: configFile, timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("NewSafeConfig failed: %v", err)
+	}
+	manager := newTargetManager(t.Context(), state, newClientPool(), time.Hour)
+	manager.Sync()
+
+	if err := os.WriteFile(configFile, []byte(`
+targets:
+  - name: array1
+    host: `+host+`
+    login: probeuser
+    password: probepass
+    tls_config:
+      insecure_skip_verify: true
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			if err := manager.Reload(); err != nil {
+				t.Errorf("Reload failed: %v", err)
+			}
+		})
+	}
+	wg.Wait()
+
+	if _, ok := manager.targets["array1"]; !ok || len(manager.targets) != 1 {
+		t.Errorf("Expected the reloaded target to be running, got %d targets", len(manager.targets))
+	}
+	waitForMetric(t, manager, "msa_disk_temperature")
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/exporter-toolkit/web"
+)
+
+// webFlags builds the listener settings of the exporter's HTTP server.
+// Without --web.listen-address it listens on all interfaces on --port.
+// The web configuration file uses the exporter-toolkit format and
+// enables HTTPS, basic authentication and client certificates.
+func webFlags(listenAddresses []string, port int, configFile string) (*web.FlagConfig, error) {
+	if len(listenAddresses) == 0 {
+		listenAddresses = []string{fmt.Sprintf(":%d", port)}
+	}
+	if configFile != "" {
+		if err := web.Validate(configFile); err != nil {
+			return nil, fmt.Errorf("invalid web configuration %s: %w", configFile, err)
+		}
+	}
+	systemdSocket := false
+	return &web.FlagConfig{
+		WebListenAddresses: &listenAddresses,
+		WebSystemdSocket:   &systemdSocket,
+		WebConfigFile:      &configFile,
+	}, nil
+}
+
+// reservedPaths are served by the exporter besides the metrics
+var reservedPaths = []string{"/probe", "/health", "/-/healthy", "/-/ready", "/-/reload"}
+
+// validateTelemetryPath checks the path metrics are served on
+func validateTelemetryPath(path string) error {
+	if !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("telemetry path %q must start with /", path)
+	}
+	if slices.Contains(reservedPaths, path) {
+		return fmt.Errorf("telemetry path %q is used by another endpoint", path)
+	}
+	return nil
+}
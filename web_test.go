@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/exporter-toolkit/web"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestWebFlags(t *testing.T) {
+	flags, err := webFlags(nil, 9000, "")
+	if err != nil {
+		t.Fatalf("webFlags failed: %v", err)
+	}
+	if addresses := *flags.WebListenAddresses; len(addresses) != 1 || addresses[0] != ":9000" {
+		t.Errorf("Expected to listen on :9000, got %v", addresses)
+	}
+
+	flags, err = webFlags([]string{"127.0.0.1:9000", "[::1]:9000"}, 9000, "")
+	if err != nil {
+		t.Fatalf("webFlags failed: %v", err)
+	}
+	if addresses := *flags.WebListenAddresses; len(addresses) != 2 || addresses[0] != "127.0.0.1:9000" {
+		t.Errorf("Expected listen addresses to be kept, got %v", addresses)
+	}
+
+	if _, err := webFlags(nil, 9000, writeTestFile(t, "web.yml", "basic_auth_users:\n  admin: not-a-hash\n")); err == nil {
+		t.Error("Expected error for invalid web configuration")
+	}
+}
+
+func TestValidateTelemetryPath(t *testing.T) {
+	for _, path := range []string{"/metrics", "/msa/metrics"} {
+		if err := validateTelemetryPath(path); err != nil {
+			t.Errorf("Expected %s to be valid: %v", path, err)
+		}
+	}
+	for _, path := range []string{"metrics", "/probe", "/-/ready"} {
+		if err := validateTelemetryPath(path); err == nil {
+			t.Errorf("Expected %s to be rejected", path)
+		}
+	}
+}
+
+func TestWebConfigAuth(t *testing.T) {
+	// Reuse the certificate of a test server for the exporter's endpoint
+	certServer := httptest.NewTLSServer(http.NotFoundHandler())
+	certServer.Close()
+	cert := certServer.TLS.Certificates[0]
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := writeTestFile(t, "server.crt", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})))
+	keyFile := writeTestFile(t, "server.key", string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})))
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFile := writeTestFile(t, "web.yml", fmt.Sprintf(`tls_server_config:
+  cert_file: %s
+  key_file: %s
+basic_auth_users:
+  prometheus: %s
+`, certFile, keyFile, hash))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags, err := webFlags([]string{listener.Addr().String()}, 0, configFile)
+	if err != nil {
+		t.Fatalf("webFlags failed: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("msa_up 1\n"))
+	})
+	server := &http.Server{Handler: mux}
+	done := make(chan error, 1)
+	go func() { done <- web.Serve(listener, server, flags, slog.New(slog.DiscardHandler)) }()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+		if err := <-done; err != nil && !errors.Is(err, http.ErrServerClosed) {
+			t.Errorf("Serve failed: %v", err)
+		}
+	}()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	url := "https://" + listener.Addr().String() + "/metrics"
+	for _, tc := range []struct {
+		user, password string
+		expected       int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"prometheus", "wrong", http.StatusUnauthorized},
+		{"prometheus", "secret", http.StatusOK},
+	} {
+		req, _ := http.NewRequest("GET", url, nil)
+		if tc.user != "" {
+			req.SetBasicAuth(tc.user, tc.password)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.expected {
+			t.Errorf("User %q: expected status %d, got %d", tc.user, tc.expected, resp.StatusCode)
+		}
+	}
+}